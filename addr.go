@@ -0,0 +1,75 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// newResolverFromAddr creates the resolver implementation matching the scheme of addr.
+//
+// Supported forms:
+//
+//   - "8.8.8.8", "8.8.8.8:53", "udp://8.8.8.8:53": UDP with TCP fallback
+//   - "tcp://8.8.8.8:53": TCP
+//   - "tls://1.1.1.1:853", "tls://1.1.1.1:853#cloudflare-dns.com": DNS-over-TLS
+//   - "https://dns.google/dns-query": DNS-over-HTTPS
+//   - "quic://94.140.14.140:853#dns.adguard-dns.com": DNS-over-QUIC
+//
+// For tls:// and quic://, the optional fragment sets the TLS server name used for SNI
+// and certificate verification, which is needed when the address is an IP that isn't
+// listed in the server's certificate.
+func newResolverFromAddr(addr string, timeout time.Duration, poolSize int) (Resolver, error) {
+	// Plain addresses without a scheme are UDP, as they've always been
+	if !strings.Contains(addr, "://") {
+		return newUDPResolver(addr, timeout, poolSize), nil
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid resolver address %q: %w", addr, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid resolver address %q: missing host", addr)
+	}
+
+	// DoH endpoints need a path, for everything else a path means the user probably
+	// made a mistake, so don't silently ignore it
+	if u.Scheme != "https" && (u.Path != "" || u.RawQuery != "") {
+		return nil, fmt.Errorf("invalid resolver address %q: unexpected path or query for scheme %q", addr, u.Scheme)
+	}
+
+	// u.Host keeps the brackets around IPv6 addresses, so rebuild it from its parts to get
+	// an address the resolvers can add their default port to
+	hostPort := func(defaultPort string) string {
+		port := u.Port()
+		if port == "" {
+			port = defaultPort
+		}
+		return net.JoinHostPort(u.Hostname(), port)
+	}
+
+	switch u.Scheme {
+	case "udp":
+		return newUDPResolver(hostPort("53"), timeout, poolSize), nil
+	case "tcp":
+		return newTCPResolver(hostPort("53"), timeout, poolSize), nil
+	case "tls":
+		return newDoTResolver(hostPort("853"), &tls.Config{ServerName: u.Fragment}, timeout, poolSize), nil
+	case "https":
+		u.Fragment = ""
+		return newDoHResolver(u.String(), DoHConfig{}, timeout, poolSize), nil
+	case "quic":
+		return newDoQResolver(hostPort("853"), &tls.Config{ServerName: u.Fragment}, timeout), nil
+	default:
+		return nil, fmt.Errorf("invalid resolver address %q: unsupported scheme %q", addr, u.Scheme)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewResolverFromAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		name string
+	}{
+		{addr: "8.8.8.8", name: "8.8.8.8:53"},
+		{addr: "8.8.8.8:5353", name: "8.8.8.8:5353"},
+		{addr: "[2001:4860:4860::8888]:53", name: "[2001:4860:4860::8888]:53"},
+		{addr: "udp://8.8.8.8", name: "8.8.8.8:53"},
+		{addr: "tcp://8.8.8.8:53", name: "tcp://8.8.8.8:53"},
+		{addr: "tls://1.1.1.1#cloudflare-dns.com", name: "tls://1.1.1.1:853"},
+		{addr: "https://dns.google/dns-query", name: "https://dns.google/dns-query"},
+		{addr: "quic://94.140.14.140", name: "quic://94.140.14.140:853"},
+		{addr: "2001:db8::1", name: "[2001:db8::1]:53"},
+		{addr: "[2001:db8::1]", name: "[2001:db8::1]:53"},
+		{addr: "udp://[2001:db8::1]", name: "[2001:db8::1]:53"},
+		{addr: "udp://[2001:db8::1]:5353", name: "[2001:db8::1]:5353"},
+		{addr: "tcp://[2001:db8::1]", name: "tcp://[2001:db8::1]:53"},
+		{addr: "tls://[2001:db8::1]#dns.example", name: "tls://[2001:db8::1]:853"},
+		{addr: "quic://[2001:db8::1]:8853", name: "quic://[2001:db8::1]:8853"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			res, err := newResolverFromAddr(tt.addr, time.Second, 1)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.name, res.Name())
+		})
+	}
+}
+
+func TestNewResolverFromAddr_Invalid(t *testing.T) {
+	for _, addr := range []string{
+		"sctp://8.8.8.8",
+		"tcp://",
+		"tcp://8.8.8.8/dns-query",
+		"https://%zz",
+	} {
+		t.Run(addr, func(t *testing.T) {
+			_, err := newResolverFromAddr(addr, time.Second, 1)
+
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestDialer_InvalidResolverAddress(t *testing.T) {
+	dialer := New(
+		WithResolvers("8.8.8.8", "dns://8.8.8.8"),
+	)
+
+	assert.Error(t, dialer.Err())
+	assert.Len(t, dialer.resolvers, 1)
+
+	_, err := dialer.DialContext(context.Background(), "tcp", "example.com:443")
+	assert.ErrorIs(t, err, dialer.Err())
+}
@@ -0,0 +1,359 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"math/bits"
+	"net"
+	"net/netip"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// sourceCacheTTL is how long the source address found for a destination is reused.
+	// Short, as it changes along with the network, e.g. when a laptop switches networks.
+	sourceCacheTTL = 5 * time.Second
+
+	// sourceCacheSize bounds the number of destinations we remember sources for
+	sourceCacheSize = 1024
+)
+
+// AddressPreference determines the order in which DialContext tries the addresses a host
+// resolves to.
+type AddressPreference int
+
+const (
+	// RFC6724 orders addresses with the destination address selection rules of RFC 6724,
+	// based on the source address the system would use to reach each of them and the
+	// policy table. Addresses the host has no route to go last, so IPv6-only hosts don't
+	// waste attempts on IPv4 addresses and vice versa. This is the default.
+	//
+	// The source addresses are looked up when dialing, as routes change. Each lookup
+	// opens a UDP socket without sending anything, and is reused for a few seconds, so a
+	// burst of dials to the same addresses doesn't repeat it every time.
+	RFC6724 AddressPreference = iota
+
+	// PreferIPv4 tries IPv4 addresses before IPv6 addresses.
+	PreferIPv4
+
+	// PreferIPv6 tries IPv6 addresses before IPv4 addresses.
+	PreferIPv6
+)
+
+// PolicyEntry is an entry of the RFC 6724 policy table, which assigns a precedence and a
+// label to the addresses matching Prefix. Addresses match the entry with the longest
+// matching prefix. IPv4 prefixes apply to the IPv4-mapped form of the addresses, e.g.
+// 10.0.0.0/8 is the same as ::ffff:10.0.0.0/104.
+type PolicyEntry struct {
+	Prefix netip.Prefix
+
+	// Precedence orders destinations, higher is preferred
+	Precedence uint8
+
+	// Label groups addresses, destinations are preferred when their source address has the
+	// same label, e.g. to use IPv6 tunnels only to reach other tunneled addresses
+	Label uint8
+}
+
+// defaultPolicyTable is the policy table from RFC 6724 section 2.1.
+var defaultPolicyTable = normalizePolicyTable([]PolicyEntry{
+	{Prefix: netip.MustParsePrefix("::1/128"), Precedence: 50, Label: 0},
+	{Prefix: netip.MustParsePrefix("::/0"), Precedence: 40, Label: 1},
+	{Prefix: netip.MustParsePrefix("::ffff:0:0/96"), Precedence: 35, Label: 4},
+	{Prefix: netip.MustParsePrefix("2002::/16"), Precedence: 30, Label: 2},
+	{Prefix: netip.MustParsePrefix("2001::/32"), Precedence: 5, Label: 5},
+	{Prefix: netip.MustParsePrefix("fc00::/7"), Precedence: 3, Label: 13},
+	{Prefix: netip.MustParsePrefix("::/96"), Precedence: 1, Label: 3},
+	{Prefix: netip.MustParsePrefix("fec0::/10"), Precedence: 1, Label: 11},
+	{Prefix: netip.MustParsePrefix("3ffe::/16"), Precedence: 1, Label: 12},
+})
+
+// normalizePolicyTable returns a copy of table with IPv4 prefixes mapped to IPv6, sorted
+// by prefix length, longest first, so the first match is the longest matching prefix.
+func normalizePolicyTable(table []PolicyEntry) []PolicyEntry {
+	normalized := make([]PolicyEntry, 0, len(table))
+	for _, entry := range table {
+		if !entry.Prefix.IsValid() {
+			continue
+		}
+		if entry.Prefix.Addr().Is4() {
+			mapped := netip.AddrFrom16(entry.Prefix.Addr().As16())
+			entry.Prefix = netip.PrefixFrom(mapped, entry.Prefix.Bits()+96)
+		}
+		entry.Prefix = entry.Prefix.Masked()
+		normalized = append(normalized, entry)
+	}
+	sort.SliceStable(normalized, func(i, j int) bool {
+		return normalized[i].Prefix.Bits() > normalized[j].Prefix.Bits()
+	})
+	return normalized
+}
+
+// classify returns the policy table entry matching addr. An address no entry matches has
+// precedence and label zero.
+func classify(table []PolicyEntry, addr netip.Addr) PolicyEntry {
+	mapped := netip.AddrFrom16(addr.As16())
+	for _, entry := range table {
+		if entry.Prefix.Contains(mapped) {
+			return entry
+		}
+	}
+	return PolicyEntry{}
+}
+
+// Address scopes from RFC 4291 section 2.7, as used by RFC 6724.
+const (
+	scopeLinkLocal = 0x2
+	scopeSiteLocal = 0x5
+	scopeGlobal    = 0xe
+)
+
+// addrScope returns the scope of addr. IPv4 loopback and link-local addresses have
+// link-local scope, all other IPv4 addresses global scope (RFC 6724 section 3.2).
+func addrScope(addr netip.Addr) uint8 {
+	if addr.Is4() {
+		if addr.IsLoopback() || addr.IsLinkLocalUnicast() {
+			return scopeLinkLocal
+		}
+		return scopeGlobal
+	}
+
+	b := addr.As16()
+	switch {
+	case addr.IsMulticast():
+		return b[1] & 0xf
+	case addr.IsLoopback(), addr.IsLinkLocalUnicast():
+		return scopeLinkLocal
+	case b[0] == 0xfe && b[1]&0xc0 == 0xc0:
+		// Deprecated site-local addresses, fec0::/10
+		return scopeSiteLocal
+	default:
+		return scopeGlobal
+	}
+}
+
+// routeSourceAddr returns the source address the system would use to reach dst, found by
+// connecting a UDP socket, which doesn't send any packets. It reports false if there's
+// no route to dst.
+func routeSourceAddr(dst netip.Addr) (netip.Addr, bool) {
+	conn, err := net.DialUDP("udp", nil, net.UDPAddrFromAddrPort(netip.AddrPortFrom(dst, 9)))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	defer conn.Close()
+
+	local, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return netip.Addr{}, false
+	}
+	return local.AddrPort().Addr().Unmap(), true
+}
+
+// sourceCache remembers the source address found for each destination for a short while,
+// so dialing the same addresses over and over doesn't open a socket for every one of them
+// on every dial.
+type sourceCache struct {
+	// lookup finds the source address for a destination, routeSourceAddr in practice
+	lookup func(dst netip.Addr) (netip.Addr, bool)
+
+	mu      sync.Mutex
+	entries map[netip.Addr]sourceEntry
+
+	// now returns the current time, replaced in tests
+	now func() time.Time
+}
+
+// sourceEntry is the source address found for a destination, ok is false if there was no
+// route to it.
+type sourceEntry struct {
+	src     netip.Addr
+	ok      bool
+	expires time.Time
+}
+
+func newSourceCache(lookup func(dst netip.Addr) (netip.Addr, bool)) *sourceCache {
+	return &sourceCache{
+		lookup:  lookup,
+		entries: make(map[netip.Addr]sourceEntry),
+		now:     time.Now,
+	}
+}
+
+// sourceAddr returns the source address for dst, looking it up if it isn't known or the
+// last lookup is older than sourceCacheTTL.
+func (c *sourceCache) sourceAddr(dst netip.Addr) (netip.Addr, bool) {
+	c.mu.Lock()
+	entry, ok := c.entries[dst]
+	c.mu.Unlock()
+	if ok && c.now().Before(entry.expires) {
+		return entry.src, entry.ok
+	}
+
+	// Looked up without holding the lock, a few concurrent dials may do it twice, which is
+	// cheaper than making all of them wait
+	src, found := c.lookup(dst)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= sourceCacheSize {
+		// Rather than tracking usage, start over, the entries expire quickly anyway
+		clear(c.entries)
+	}
+	c.entries[dst] = sourceEntry{src: src, ok: found, expires: c.now().Add(sourceCacheTTL)}
+	return src, found
+}
+
+// dialOrder returns ips in the order DialContext tries them on network. They're sorted by
+// the address preference, and for "tcp" and "udp", which may use both families, alternate
+// between IPv4 and IPv6 from there, so a broken path of one family only costs a single
+// attempt. Addresses RFC 6724 found no route to aren't part of that, they stay last.
+func (r *Dialer) dialOrder(network string, ips []net.IP) []net.IP {
+	sorted, usable := r.sortAddrs(ips)
+	if network != "tcp" && network != "udp" {
+		return sorted
+	}
+	return append(interleaveFamilies(sorted[:usable]), sorted[usable:]...)
+}
+
+// sortAddrs returns ips in the order DialContext should try them, according to the address
+// preference, and how many of them, from the start, the host has a route to. The order
+// within each family is kept as the resolvers returned it where the preference doesn't
+// say otherwise. ips itself isn't modified, it may be cached.
+func (r *Dialer) sortAddrs(ips []net.IP) ([]net.IP, int) {
+	sorted := slices.Clone(ips)
+	if len(sorted) < 2 {
+		return sorted, len(sorted)
+	}
+
+	switch r.addrPreference {
+	case PreferIPv4, PreferIPv6:
+		preferV4 := r.addrPreference == PreferIPv4
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return (sorted[i].To4() != nil) == preferV4 && (sorted[j].To4() != nil) != preferV4
+		})
+		return sorted, len(sorted)
+	default:
+		return sorted, r.sortRFC6724(sorted)
+	}
+}
+
+// destination holds what RFC 6724 destination address selection compares about an address.
+type destination struct {
+	addr   netip.Addr
+	src    netip.Addr
+	hasSrc bool
+	policy PolicyEntry
+
+	// srcLabel is the label of the source address
+	srcLabel uint8
+}
+
+// sortRFC6724 sorts ips in place with the destination address selection rules of RFC 6724
+// section 6, and returns how many of them have a usable source address. Rules 3, 4 and 7
+// need information about the source addresses that isn't portably available, so they're
+// skipped, as most implementations do.
+func (r *Dialer) sortRFC6724(ips []net.IP) int {
+	table := r.policyTable
+	if table == nil {
+		table = defaultPolicyTable
+	}
+
+	usable := 0
+	dsts := make([]destination, len(ips))
+	for i, ip := range ips {
+		addr, _ := netip.AddrFromSlice(ip)
+		dst := destination{addr: addr.Unmap()}
+		dst.src, dst.hasSrc = r.sourceAddr(dst.addr)
+		dst.policy = classify(table, dst.addr)
+		if dst.hasSrc {
+			dst.srcLabel = classify(table, dst.src).Label
+			usable++
+		}
+		dsts[i] = dst
+	}
+
+	order := make([]int, len(ips))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return preferDestination(dsts[order[i]], dsts[order[j]])
+	})
+
+	sorted := make([]net.IP, len(ips))
+	for i, idx := range order {
+		sorted[i] = ips[idx]
+	}
+	copy(ips, sorted)
+	return usable
+}
+
+// preferDestination reports whether a should be tried before b by the rules of RFC 6724
+// section 6. If no rule prefers either, their order is left as is.
+func preferDestination(a, b destination) bool {
+	// Rule 1: avoid unusable destinations
+	if a.hasSrc != b.hasSrc {
+		return a.hasSrc
+	}
+	if !a.hasSrc {
+		return false
+	}
+
+	// Rule 2: prefer matching scope
+	aScope, bScope := addrScope(a.addr), addrScope(b.addr)
+	aMatch, bMatch := aScope == addrScope(a.src), bScope == addrScope(b.src)
+	if aMatch != bMatch {
+		return aMatch
+	}
+
+	// Rule 5: prefer matching label
+	aMatch, bMatch = a.policy.Label == a.srcLabel, b.policy.Label == b.srcLabel
+	if aMatch != bMatch {
+		return aMatch
+	}
+
+	// Rule 6: prefer higher precedence
+	if a.policy.Precedence != b.policy.Precedence {
+		return a.policy.Precedence > b.policy.Precedence
+	}
+
+	// Rule 8: prefer smaller scope
+	if aScope != bScope {
+		return aScope < bScope
+	}
+
+	// Rule 9: use longest matching prefix. Like most implementations, only for IPv6, and
+	// only up to the 64 bits of the network prefix, applied to IPv4 it would undo the
+	// round robin of DNS servers that rotate their answers.
+	if a.addr.Is6() && b.addr.Is6() {
+		aLen, bLen := commonPrefixLen(a.addr, a.src), commonPrefixLen(b.addr, b.src)
+		if aLen != bLen {
+			return aLen > bLen
+		}
+	}
+
+	// Rule 10: otherwise, leave the order unchanged
+	return false
+}
+
+// commonPrefixLen returns the number of leading bits a and b have in common, up to 64.
+func commonPrefixLen(a, b netip.Addr) int {
+	if a.Is4() != b.Is4() {
+		return 0
+	}
+	ab, bb := a.As16(), b.As16()
+	n := 0
+	for i := 0; i < 8; i++ {
+		if x := ab[i] ^ bb[i]; x != 0 {
+			return n + bits.LeadingZeros8(x)
+		}
+		n += 8
+	}
+	return n
+}
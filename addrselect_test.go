@@ -0,0 +1,210 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// staticSources returns a source address lookup that picks the first of srcs in the same
+// family as the destination, like a host with one address of each family would.
+func staticSources(srcs ...string) func(dst netip.Addr) (netip.Addr, bool) {
+	return func(dst netip.Addr) (netip.Addr, bool) {
+		for _, s := range srcs {
+			if src := netip.MustParseAddr(s); src.Is4() == dst.Is4() {
+				return src, true
+			}
+		}
+		return netip.Addr{}, false
+	}
+}
+
+func parseIPs(addrs ...string) []net.IP {
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = net.ParseIP(addr)
+	}
+	return ips
+}
+
+func TestDialer_SortAddrs(t *testing.T) {
+	tests := []struct {
+		name    string
+		pref    AddressPreference
+		table   []PolicyEntry
+		sources []string
+		ips     []string
+		sorted  []string
+	}{
+		{
+			name:    "dual stack prefers IPv6",
+			sources: []string{"2001:db8:1::10", "198.51.100.10"},
+			ips:     []string{"192.0.2.1", "2001:db8:1::1", "192.0.2.2"},
+			sorted:  []string{"2001:db8:1::1", "192.0.2.1", "192.0.2.2"},
+		},
+		{
+			name:    "IPv6 only host",
+			sources: []string{"2001:db8:1::10"},
+			ips:     []string{"192.0.2.1", "2001:db8:1::1"},
+			sorted:  []string{"2001:db8:1::1", "192.0.2.1"},
+		},
+		{
+			name:    "IPv4 only host",
+			sources: []string{"198.51.100.10"},
+			ips:     []string{"2001:db8:1::1", "192.0.2.1"},
+			sorted:  []string{"192.0.2.1", "2001:db8:1::1"},
+		},
+		{
+			name:    "matching label beats precedence",
+			sources: []string{"fd00::10", "198.51.100.10"},
+			ips:     []string{"2001:db8:1::1", "192.0.2.1", "fd00::1"},
+			sorted:  []string{"192.0.2.1", "fd00::1", "2001:db8:1::1"},
+		},
+		{
+			name:    "longest matching prefix",
+			sources: []string{"2001:db8:1::10"},
+			ips:     []string{"2001:db8:2::1", "2001:db8:1::1"},
+			sorted:  []string{"2001:db8:1::1", "2001:db8:2::1"},
+		},
+		{
+			name:    "IPv4 keeps its order",
+			sources: []string{"198.51.100.10"},
+			ips:     []string{"203.0.113.1", "198.51.100.1"},
+			sorted:  []string{"203.0.113.1", "198.51.100.1"},
+		},
+		{
+			name: "policy table preferring IPv4",
+			table: []PolicyEntry{
+				{Prefix: netip.MustParsePrefix("::/0"), Precedence: 40, Label: 1},
+				{Prefix: netip.MustParsePrefix("0.0.0.0/0"), Precedence: 100, Label: 4},
+			},
+			sources: []string{"2001:db8:1::10", "198.51.100.10"},
+			ips:     []string{"2001:db8:1::1", "192.0.2.1"},
+			sorted:  []string{"192.0.2.1", "2001:db8:1::1"},
+		},
+		{
+			name:    "prefer IPv4",
+			pref:    PreferIPv4,
+			sources: []string{"2001:db8:1::10"},
+			ips:     []string{"2001:db8:1::1", "192.0.2.1", "2001:db8:1::2"},
+			sorted:  []string{"192.0.2.1", "2001:db8:1::1", "2001:db8:1::2"},
+		},
+		{
+			name:    "prefer IPv6",
+			pref:    PreferIPv6,
+			sources: []string{"198.51.100.10"},
+			ips:     []string{"192.0.2.1", "2001:db8:1::1"},
+			sorted:  []string{"2001:db8:1::1", "192.0.2.1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []Option{WithAddressPreference(tt.pref)}
+			if tt.table != nil {
+				opts = append(opts, WithPolicyTable(tt.table))
+			}
+			dialer := New(opts...)
+			dialer.sourceAddr = staticSources(tt.sources...)
+
+			ips := parseIPs(tt.ips...)
+			sorted, _ := dialer.sortAddrs(ips)
+			assert.Equal(t, parseIPs(tt.sorted...), sorted)
+
+			// The addresses passed in may be cached, they're left alone
+			assert.Equal(t, parseIPs(tt.ips...), ips)
+		})
+	}
+}
+
+func TestDialer_DialOrder(t *testing.T) {
+	ips := parseIPs("2001:db8:1::1", "2001:db8:1::2", "192.0.2.1", "192.0.2.2")
+
+	tests := []struct {
+		name    string
+		network string
+		sources []string
+		order   []string
+	}{
+		{
+			name:    "dual stack alternates families",
+			network: "tcp",
+			sources: []string{"2001:db8:1::10", "198.51.100.10"},
+			order:   []string{"2001:db8:1::1", "192.0.2.1", "2001:db8:1::2", "192.0.2.2"},
+		},
+		{
+			name:    "IPv6-only host tries IPv4 last",
+			network: "tcp",
+			sources: []string{"2001:db8:1::10"},
+			order:   []string{"2001:db8:1::1", "2001:db8:1::2", "192.0.2.1", "192.0.2.2"},
+		},
+		{
+			name:    "IPv4-only host tries IPv6 last",
+			network: "udp",
+			sources: []string{"198.51.100.10"},
+			order:   []string{"192.0.2.1", "192.0.2.2", "2001:db8:1::1", "2001:db8:1::2"},
+		},
+		{
+			name:    "no route at all keeps the order",
+			network: "tcp",
+			order:   []string{"2001:db8:1::1", "2001:db8:1::2", "192.0.2.1", "192.0.2.2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialer := New()
+			dialer.sourceAddr = staticSources(tt.sources...)
+
+			assert.Equal(t, parseIPs(tt.order...), dialer.dialOrder(tt.network, ips))
+		})
+	}
+}
+
+func TestAddrScope(t *testing.T) {
+	assert.Equal(t, uint8(scopeLinkLocal), addrScope(netip.MustParseAddr("127.0.0.1")))
+	assert.Equal(t, uint8(scopeLinkLocal), addrScope(netip.MustParseAddr("169.254.1.1")))
+	assert.Equal(t, uint8(scopeGlobal), addrScope(netip.MustParseAddr("10.0.0.1")))
+	assert.Equal(t, uint8(scopeLinkLocal), addrScope(netip.MustParseAddr("fe80::1")))
+	assert.Equal(t, uint8(scopeSiteLocal), addrScope(netip.MustParseAddr("fec0::1")))
+	assert.Equal(t, uint8(0x5), addrScope(netip.MustParseAddr("ff05::2")))
+	assert.Equal(t, uint8(scopeGlobal), addrScope(netip.MustParseAddr("2001:db8::1")))
+}
+
+func TestSourceCache(t *testing.T) {
+	now := time.Now()
+	var lookups int
+	c := newSourceCache(func(dst netip.Addr) (netip.Addr, bool) {
+		lookups++
+		return staticSources("192.0.2.100")(dst)
+	})
+	c.now = func() time.Time { return now }
+
+	dst := netip.MustParseAddr("198.51.100.1")
+	for i := 0; i < 3; i++ {
+		src, ok := c.sourceAddr(dst)
+		assert.True(t, ok)
+		assert.Equal(t, netip.MustParseAddr("192.0.2.100"), src)
+	}
+	assert.Equal(t, 1, lookups)
+
+	// Having no route is remembered as well
+	_, ok := c.sourceAddr(netip.MustParseAddr("2001:db8::1"))
+	assert.False(t, ok)
+	_, ok = c.sourceAddr(netip.MustParseAddr("2001:db8::1"))
+	assert.False(t, ok)
+	assert.Equal(t, 2, lookups)
+
+	// The route may have changed since, so it's looked up again
+	now = now.Add(sourceCacheTTL)
+	c.sourceAddr(dst)
+	assert.Equal(t, 3, lookups)
+}
@@ -7,7 +7,9 @@ package dnsdialer
 
 import (
 	"net"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	lru "github.com/hashicorp/golang-lru/v2/expirable"
@@ -19,6 +21,27 @@ import (
 type ipCacheEntry struct {
 	ips       []net.IP
 	expiresAt time.Time
+
+	// failedAt is set when refreshing this entry after it expired failed, so we know the
+	// resolvers were recently unable to answer (only used with serve-stale)
+	failedAt time.Time
+
+	// ttl is the clamped TTL the entry was stored with, used to tell how far into its
+	// lifetime the entry is for prefetching
+	ttl time.Duration
+
+	// hits counts cache hits, so only popular hosts get prefetched
+	hits atomic.Uint64
+
+	// prefetching is set once a prefetch has been requested for this entry, so it's
+	// requested only once. The refreshed answer replaces the whole entry.
+	prefetching atomic.Bool
+
+	// negative marks an entry caching the fact that the host has no addresses (RFC 2308),
+	// ips is empty then. nxdomain tells whether the name doesn't exist at all, or just has
+	// no A/AAAA records.
+	negative bool
+	nxdomain bool
 }
 
 // isExpired checks if the IP cache entry has expired based on DNS TTL.
@@ -26,6 +49,46 @@ func (e *ipCacheEntry) isExpired() bool {
 	return time.Now().After(e.expiresAt)
 }
 
+// recordCacheEntry holds the records of a single host and record type, as used by the
+// Lookup methods for anything other than plain IP lookups.
+type recordCacheEntry struct {
+	records   []Record
+	expiresAt time.Time
+
+	// notFound is set for a cached negative answer, records is empty then
+	notFound *NotFoundError
+}
+
+// isExpired checks if the record cache entry has expired based on DNS TTL.
+func (e *recordCacheEntry) isExpired() bool {
+	return time.Now().After(e.expiresAt)
+}
+
+// cacheConfig holds the cache settings collected from options. The cache is created once
+// all options have been applied, so the order of options like WithCache and WithServeStale
+// doesn't matter.
+type cacheConfig struct {
+	// size is the maximum number of hostnames to cache, 0 disables the cache
+	size int
+
+	// minTTL and maxTTL clamp the TTLs from DNS responses
+	minTTL time.Duration
+	maxTTL time.Duration
+
+	// staleWindow is how long expired entries are kept around to be served when resolution
+	// fails (RFC 8767), 0 disables serve-stale
+	staleWindow time.Duration
+
+	// prefetch configures refreshing popular entries before they expire, disabled if its
+	// Threshold is 0
+	prefetch PrefetchConfig
+
+	// negMinTTL and negMaxTTL clamp the TTLs of negative answers, negative caching is
+	// disabled if negMaxTTL is 0
+	negMinTTL time.Duration
+	negMaxTTL time.Duration
+}
+
 // dnsCache wraps an LRU cache with TTL-aware expiration for IP addresses. It mimics
 // OS-level DNS caching behavior (mDNSResponder, systemd-resolved) while providing
 // explicit control over cache size, TTL bounds, and invalidation.
@@ -34,6 +97,10 @@ type dnsCache struct {
 	mu      sync.RWMutex
 	enabled bool
 
+	// recordCache holds records by host and type, separate from ipCache so the hot IP path
+	// keeps its pre-parsed addresses. It's bounded by the same size.
+	recordCache *lru.LRU[string, *recordCacheEntry]
+
 	// minTTL prevents caching entries with very short TTLs that would just thrash the cache.
 	// For example, setting this to 1s means we won't bother caching a record with TTL=0.
 	minTTL time.Duration
@@ -41,27 +108,54 @@ type dnsCache struct {
 	// maxTTL caps how long we'll cache an entry, regardless of what the DNS resolver tells us.
 	// This ensures we periodically re-validate even if the server sends a very high TTL.
 	maxTTL time.Duration
+
+	// staleWindow is how long past expiration an entry may still be served if resolving it
+	// again fails. Zero means expired entries are never served.
+	staleWindow time.Duration
+
+	// prefetchThreshold is the fraction of its TTL an entry has to be into before a hit
+	// requests a prefetch, zero disables prefetching
+	prefetchThreshold float64
+
+	// prefetchMinHits is the number of hits an entry needs before it's prefetched
+	prefetchMinHits uint64
+
+	// negMinTTL and negMaxTTL clamp how long negative answers are cached. Negative caching
+	// is disabled if negMaxTTL is zero.
+	negMinTTL time.Duration
+	negMaxTTL time.Duration
 }
 
 // newDNSCache creates a new DNS cache with the specified size and TTL bounds.
 // Size controls the maximum number of hostnames to cache (LRU eviction when full).
 // minTTL and maxTTL clamp DNS response TTLs to prevent both cache thrashing from
 // very short TTLs and indefinite caching from very long TTLs.
-func newDNSCache(size int, minTTL, maxTTL time.Duration) *dnsCache {
-	if size <= 0 {
+func newDNSCache(cfg cacheConfig) *dnsCache {
+	if cfg.size <= 0 {
 		return &dnsCache{enabled: false}
 	}
 
 	// Create LRU cache for IP addresses. The golang-lru library handles eviction
 	// and basic TTL tracking for us, but we also check expiration manually in getIPs()
-	// since we want to respect DNS TTLs from individual records.
-	ipCache := lru.NewLRU[string, *ipCacheEntry](size, nil, maxTTL)
+	// since we want to respect DNS TTLs from individual records. With serve-stale, the
+	// LRU has to hold on to entries for the stale window on top of their TTL, and negative
+	// answers may be allowed to live longer than positive ones.
+	ipCache := lru.NewLRU[string, *ipCacheEntry](cfg.size, nil, max(cfg.maxTTL+cfg.staleWindow, cfg.negMaxTTL))
+	recordCache := lru.NewLRU[string, *recordCacheEntry](cfg.size, nil, max(cfg.maxTTL, cfg.negMaxTTL))
 
 	return &dnsCache{
-		ipCache: ipCache,
-		enabled: true,
-		minTTL:  minTTL,
-		maxTTL:  maxTTL,
+		ipCache:     ipCache,
+		recordCache: recordCache,
+		enabled:     true,
+		minTTL:      cfg.minTTL,
+		maxTTL:      cfg.maxTTL,
+		staleWindow: cfg.staleWindow,
+
+		prefetchThreshold: cfg.prefetch.Threshold,
+		prefetchMinHits:   uint64(cfg.prefetch.MinHits),
+
+		negMinTTL: cfg.negMinTTL,
+		negMaxTTL: cfg.negMaxTTL,
 	}
 }
 
@@ -69,9 +163,13 @@ func newDNSCache(size int, minTTL, maxTTL time.Duration) *dnsCache {
 // This is the fast path for lookupIPs() and is crucial for performance. By caching
 // parsed net.IP values instead of DNS records, we avoid calling net.ParseIP on every
 // cache hit.
-func (c *dnsCache) getIPs(host string) []net.IP {
+//
+// With prefetching enabled, the returned bool reports whether the caller should refresh
+// the entry in the background, because it's popular and close to expiring. It's true at
+// most once per entry.
+func (c *dnsCache) getIPs(host string) ([]net.IP, bool) {
 	if !c.enabled {
-		return nil
+		return nil, false
 	}
 
 	c.mu.RLock()
@@ -79,20 +177,44 @@ func (c *dnsCache) getIPs(host string) []net.IP {
 
 	entry, ok := c.ipCache.Get(host)
 	if !ok {
-		return nil
+		return nil, false
 	}
 
 	// Same expiration logic as the record cache, don't bother removing it, just return
-	// nil to signal a cache miss. The LRU will evict it eventually.
-	if entry.isExpired() {
-		return nil
+	// nil to signal a cache miss. The LRU will evict it eventually. Negative entries have
+	// no addresses to return, getNegative handles those.
+	if entry.negative || entry.isExpired() {
+		return nil, false
 	}
 
 	// Return a copy to prevent the caller from modifying our cached data. net.IP is a
 	// slice, so we need to copy the slice itself, not just the individual IP values.
 	ips := make([]net.IP, len(entry.ips))
 	copy(ips, entry.ips)
-	return ips
+	return ips, c.shouldPrefetch(entry)
+}
+
+// shouldPrefetch counts a hit on entry and reports whether it's time to refresh it ahead
+// of expiration: it has been hit often enough and is past the prefetch threshold of its TTL.
+func (c *dnsCache) shouldPrefetch(entry *ipCacheEntry) bool {
+	if c.prefetchThreshold <= 0 {
+		return false
+	}
+
+	hits := entry.hits.Add(1)
+	if hits < c.prefetchMinHits {
+		return false
+	}
+
+	// Only prefetch once the entry is far enough into its lifetime, e.g. with a threshold of
+	// 0.9 and a TTL of 60s, hits in the last 6 seconds before expiration trigger a prefetch.
+	remaining := time.Until(entry.expiresAt)
+	if remaining > time.Duration(float64(entry.ttl)*(1-c.prefetchThreshold)) {
+		return false
+	}
+
+	// Several callers can get here concurrently, only one of them gets to prefetch
+	return entry.prefetching.CompareAndSwap(false, true)
 }
 
 // setIPs stores already-parsed IP addresses in the cache with TTL-based expiration.
@@ -111,12 +233,191 @@ func (c *dnsCache) setIPs(host string, ips []net.IP, ttl time.Duration) {
 		ttl = c.maxTTL
 	}
 
+	// Store our own copy, the caller goes on to hand ips out to everyone waiting on the
+	// lookup, and they may modify it.
 	entry := &ipCacheEntry{
-		ips:       ips,
+		ips:       slices.Clone(ips),
 		expiresAt: time.Now().Add(ttl),
+		ttl:       ttl,
 	}
 
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.ipCache.Add(host, entry)
 }
+
+// getNegative returns the cached negative answer for a hostname, or nil if there is none
+// or it has expired. The returned error is marked as Cached.
+func (c *dnsCache) getNegative(host string) *NotFoundError {
+	if !c.enabled || c.negMaxTTL <= 0 {
+		return nil
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entry, ok := c.ipCache.Get(host)
+	if !ok || !entry.negative || entry.isExpired() {
+		return nil
+	}
+
+	return &NotFoundError{Host: host, NXDomain: entry.nxdomain, Cached: true}
+}
+
+// setNegative caches the fact that a hostname has no addresses (RFC 2308). The TTL comes
+// from the SOA record of the negative response and is clamped to the negative cache bounds.
+// A zero TTL means the response carried no SOA, and such answers aren't cached at all.
+func (c *dnsCache) setNegative(host string, nxdomain bool, ttl time.Duration) {
+	if !c.enabled || c.negMaxTTL <= 0 || ttl <= 0 {
+		return
+	}
+
+	if ttl < c.negMinTTL {
+		ttl = c.negMinTTL
+	}
+	if ttl > c.negMaxTTL {
+		ttl = c.negMaxTTL
+	}
+
+	entry := &ipCacheEntry{
+		expiresAt: time.Now().Add(ttl),
+		ttl:       ttl,
+		negative:  true,
+		nxdomain:  nxdomain,
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ipCache.Add(host, entry)
+}
+
+// recordCacheKey is the record cache key for host and qtype.
+func recordCacheKey(host string, qtype RecordType) string {
+	return host + "|" + qtype.String()
+}
+
+// getRecords retrieves cached records of qtype for a hostname. If the cache holds a negative
+// answer for them, that's returned as the error, marked as Cached. Both are nil on a miss.
+func (c *dnsCache) getRecords(host string, qtype RecordType) ([]Record, *NotFoundError) {
+	if !c.enabled {
+		return nil, nil
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entry, ok := c.recordCache.Get(recordCacheKey(host, qtype))
+	if !ok || entry.isExpired() {
+		return nil, nil
+	}
+
+	if entry.notFound != nil {
+		notFound := *entry.notFound
+		notFound.Cached = true
+		return nil, &notFound
+	}
+
+	// Same as with IPs, callers get their own copy. Record is a plain value type, so
+	// copying the slice is enough.
+	records := make([]Record, len(entry.records))
+	copy(records, entry.records)
+	return records, nil
+}
+
+// setRecords stores records of qtype for a hostname. They're cached for the lowest TTL
+// among them, clamped to the configured bounds.
+func (c *dnsCache) setRecords(host string, qtype RecordType, records []Record) {
+	if !c.enabled || len(records) == 0 {
+		return
+	}
+
+	ttl := time.Duration(records[0].TTL) * time.Second
+	for _, record := range records[1:] {
+		ttl = min(ttl, time.Duration(record.TTL)*time.Second)
+	}
+	if ttl < c.minTTL {
+		ttl = c.minTTL
+	}
+	if ttl > c.maxTTL {
+		ttl = c.maxTTL
+	}
+
+	entry := &recordCacheEntry{
+		records:   records,
+		expiresAt: time.Now().Add(ttl),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.recordCache.Add(recordCacheKey(host, qtype), entry)
+}
+
+// setNegativeRecords caches a negative answer for qtype records of a hostname, with the
+// same rules as setNegative.
+func (c *dnsCache) setNegativeRecords(host string, qtype RecordType, notFound *NotFoundError) {
+	if !c.enabled || c.negMaxTTL <= 0 || notFound.ttl <= 0 {
+		return
+	}
+
+	ttl := min(max(notFound.ttl, c.negMinTTL), c.negMaxTTL)
+	entry := &recordCacheEntry{
+		expiresAt: time.Now().Add(ttl),
+		notFound:  &NotFoundError{Host: host, NXDomain: notFound.NXDomain},
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.recordCache.Add(recordCacheKey(host, qtype), entry)
+}
+
+// staleEntry describes an expired cache entry that may still be served.
+type staleEntry struct {
+	ips []net.IP
+
+	// age is how long ago the entry expired
+	age time.Duration
+
+	// failedAt is when refreshing the entry last failed, zero if it hasn't been tried yet
+	failedAt time.Time
+}
+
+// getStaleIPs retrieves IP addresses for a hostname whose cache entry has expired, but is
+// still within the stale window. It's what we fall back to when resolution fails (RFC 8767).
+func (c *dnsCache) getStaleIPs(host string) (staleEntry, bool) {
+	if !c.enabled || c.staleWindow <= 0 {
+		return staleEntry{}, false
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	// Peek rather than Get, serving a stale answer shouldn't count as recent use
+	entry, ok := c.ipCache.Peek(host)
+	if !ok || entry.negative || !entry.isExpired() {
+		return staleEntry{}, false
+	}
+
+	age := time.Since(entry.expiresAt)
+	if age > c.staleWindow {
+		return staleEntry{}, false
+	}
+
+	ips := make([]net.IP, len(entry.ips))
+	copy(ips, entry.ips)
+	return staleEntry{ips: ips, age: age, failedAt: entry.failedAt}, true
+}
+
+// markFailed records that refreshing an expired entry failed, so callers can serve the
+// stale answer right away for a while instead of waiting on resolvers that are down.
+func (c *dnsCache) markFailed(host string) {
+	if !c.enabled || c.staleWindow <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.ipCache.Peek(host); ok {
+		entry.failedAt = time.Now()
+	}
+}
@@ -0,0 +1,331 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyResolver answers with a fixed A record, or fails while failing is set.
+type flakyResolver struct {
+	failing atomic.Bool
+	calls   atomic.Int32
+}
+
+func (f *flakyResolver) Name() string {
+	return "flaky"
+}
+
+func (f *flakyResolver) ResolveType(ctx context.Context, host string, qtype RecordType) ([]Record, error) {
+	f.calls.Add(1)
+	if f.failing.Load() {
+		return nil, errors.New("resolver unreachable")
+	}
+	if qtype != TypeA {
+		return nil, nil
+	}
+	return []Record{{Type: TypeA, Value: "192.0.2.1", TTL: 300}}, nil
+}
+
+func TestDNSCache_StaleWindow(t *testing.T) {
+	cache := newDNSCache(cacheConfig{size: 10, maxTTL: 20 * time.Millisecond, staleWindow: 50 * time.Millisecond})
+	cache.setIPs("example.com", []net.IP{net.ParseIP("192.0.2.1")}, time.Minute)
+
+	// Still fresh, so there's nothing stale to serve
+	_, ok := cache.getStaleIPs("example.com")
+	assert.False(t, ok)
+
+	time.Sleep(30 * time.Millisecond)
+	ips, _ := cache.getIPs("example.com")
+	assert.Nil(t, ips)
+	stale, ok := cache.getStaleIPs("example.com")
+	require.True(t, ok)
+	assert.Equal(t, "192.0.2.1", stale.ips[0].String())
+	assert.True(t, stale.failedAt.IsZero())
+
+	cache.markFailed("example.com")
+	stale, ok = cache.getStaleIPs("example.com")
+	require.True(t, ok)
+	assert.False(t, stale.failedAt.IsZero())
+
+	// Past the stale window, the entry is gone for good
+	time.Sleep(60 * time.Millisecond)
+	_, ok = cache.getStaleIPs("example.com")
+	assert.False(t, ok)
+}
+
+func TestDialer_ServeStale(t *testing.T) {
+	res := &flakyResolver{}
+	dialer := New(
+		WithCustomResolvers(res),
+		WithCache(10, 0, 20*time.Millisecond),
+		WithServeStale(time.Minute),
+	)
+	dialer.staleRecheck = 50 * time.Millisecond
+
+	_, err := dialer.lookupIPs(context.Background(), "example.com")
+	require.NoError(t, err)
+
+	// Let the answer expire, then take the resolver down
+	time.Sleep(30 * time.Millisecond)
+	res.failing.Store(true)
+
+	ips, err := dialer.lookupIPs(context.Background(), "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.1", ips[0].String())
+
+	// Refreshing just failed, so the stale answer is served without asking the resolver again
+	calls := res.calls.Load()
+	ips, err = dialer.lookupIPs(context.Background(), "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.1", ips[0].String())
+	assert.Equal(t, calls, res.calls.Load())
+
+	// Once the resolver is back, the background refresh puts a fresh answer in the cache
+	res.failing.Store(false)
+	assert.Eventually(t, func() bool {
+		ips, _ := dialer.cache.getIPs("example.com")
+		return ips != nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestDialer_ServeStale_Disabled(t *testing.T) {
+	res := &flakyResolver{}
+	dialer := New(
+		WithCustomResolvers(res),
+		WithCache(10, 0, 20*time.Millisecond),
+	)
+
+	_, err := dialer.lookupIPs(context.Background(), "example.com")
+	require.NoError(t, err)
+
+	time.Sleep(30 * time.Millisecond)
+	res.failing.Store(true)
+
+	_, err = dialer.lookupIPs(context.Background(), "example.com")
+	assert.Error(t, err)
+}
+
+func TestDialer_Prefetch(t *testing.T) {
+	res := &flakyResolver{}
+	dialer := New(
+		WithCustomResolvers(res),
+		WithCache(10, 0, 200*time.Millisecond),
+		WithPrefetch(PrefetchConfig{Threshold: 0.5, MinHits: 2, Workers: 1}),
+	)
+	defer dialer.Close()
+
+	// One query per record type to fill the cache, then a hit early in the entry's lifetime
+	_, err := dialer.lookupIPs(context.Background(), "example.com")
+	require.NoError(t, err)
+	_, err = dialer.lookupIPs(context.Background(), "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), res.calls.Load())
+
+	// A hit past half of the TTL triggers a prefetch, the caller still gets the cached answer
+	time.Sleep(120 * time.Millisecond)
+	ips, err := dialer.lookupIPs(context.Background(), "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.1", ips[0].String())
+	assert.Eventually(t, func() bool {
+		return res.calls.Load() == 4
+	}, time.Second, 5*time.Millisecond)
+
+	// Past the original expiration, the prefetched entry is still served from the cache
+	time.Sleep(100 * time.Millisecond)
+	cached, _ := dialer.cache.getIPs("example.com")
+	assert.NotNil(t, cached)
+	assert.Equal(t, int32(4), res.calls.Load())
+}
+
+func TestDialer_Close(t *testing.T) {
+	res := &flakyResolver{}
+	res.failing.Store(true)
+	dialer := New(
+		WithCustomResolvers(res),
+		WithCache(10, 0, 10*time.Millisecond),
+		WithServeStale(time.Minute),
+		WithPrefetch(PrefetchConfig{}),
+	)
+	dialer.staleRecheck = time.Hour
+	dialer.cache.setIPs("example.com", []net.IP{net.ParseIP("192.0.2.1")}, time.Minute)
+
+	// Serving the stale answer starts a background refresh, which Close has to stop
+	time.Sleep(20 * time.Millisecond)
+	_, err := dialer.lookupIPs(context.Background(), "example.com")
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		done <- dialer.Close()
+	}()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Close didn't stop the background work")
+	}
+
+	_, err = dialer.DialContext(context.Background(), "tcp", "example.com:443")
+	assert.ErrorIs(t, err, net.ErrClosed)
+	assert.NoError(t, dialer.Close())
+}
+
+// activeResolver is a mockResolver that tracks how many queries are running.
+type activeResolver struct {
+	mockResolver
+	active atomic.Int32
+}
+
+func (a *activeResolver) ResolveType(ctx context.Context, host string, qtype RecordType) ([]Record, error) {
+	a.active.Add(1)
+	defer a.active.Add(-1)
+	return a.mockResolver.ResolveType(ctx, host, qtype)
+}
+
+func TestDialer_Close_CancelsSharedLookups(t *testing.T) {
+	res := &activeResolver{mockResolver: mockResolver{name: "slow", delay: time.Hour}}
+	dialer := New(WithCustomResolvers(res))
+
+	// The lookup runs detached from the caller's context, so it's up to Close to stop it
+	errs := make(chan error, 1)
+	go func() {
+		_, err := dialer.lookupIPs(context.Background(), "example.com")
+		errs <- err
+	}()
+	require.Eventually(t, func() bool { return res.active.Load() > 0 }, time.Second, time.Millisecond)
+
+	require.NoError(t, dialer.Close())
+	assert.Zero(t, res.active.Load())
+	select {
+	case err := <-errs:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("Close didn't cancel the lookup")
+	}
+
+	// And no new one starts once the Dialer is closed
+	_, err := dialer.resolveShared(context.Background(), ipQuery{host: "example.com", qtypes: []RecordType{TypeA}}, nil)
+	assert.ErrorIs(t, err, net.ErrClosed)
+}
+
+func TestDialer_NegativeCache(t *testing.T) {
+	var queries atomic.Int32
+	addr := startTestDNSServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		queries.Add(1)
+		resp := new(dns.Msg)
+		resp.SetRcode(req, dns.RcodeNameError)
+
+		// The SOA's MINIMUM is lower than its TTL, so it's what limits negative caching
+		resp.Ns = append(resp.Ns, &dns.SOA{
+			Hdr:    dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 3600},
+			Ns:     "ns.example.com.",
+			Mbox:   "hostmaster.example.com.",
+			Minttl: 1,
+		})
+		_ = w.WriteMsg(resp)
+	})
+
+	dialer := New(
+		WithResolvers(addr),
+		WithCache(10, time.Second, time.Minute),
+		WithNegativeCache(0, time.Minute),
+	)
+	defer dialer.Close()
+
+	_, err := dialer.lookupIPs(context.Background(), "missing.example.com")
+	var notFound *NotFoundError
+	require.ErrorAs(t, err, &notFound)
+	assert.True(t, notFound.NXDomain)
+	assert.False(t, notFound.Cached)
+	assert.Equal(t, int32(2), queries.Load())
+
+	// Answered from the negative cache, without asking the resolver again
+	_, err = dialer.lookupIPs(context.Background(), "missing.example.com")
+	require.ErrorAs(t, err, &notFound)
+	assert.True(t, notFound.Cached)
+	assert.Equal(t, int32(2), queries.Load())
+
+	// Once the SOA minimum has passed, the resolver is asked again
+	time.Sleep(1100 * time.Millisecond)
+	_, err = dialer.lookupIPs(context.Background(), "missing.example.com")
+	require.ErrorAs(t, err, &notFound)
+	assert.False(t, notFound.Cached)
+	assert.Equal(t, int32(4), queries.Load())
+}
+
+func TestDialer_NegativeCache_SingleFamily(t *testing.T) {
+	var queries atomic.Int32
+	addr := startTestDNSServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		queries.Add(1)
+		resp := new(dns.Msg)
+		resp.SetRcode(req, dns.RcodeNameError)
+		resp.Ns = append(resp.Ns, &dns.SOA{
+			Hdr:    dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 60},
+			Ns:     "ns.example.com.",
+			Mbox:   "hostmaster.example.com.",
+			Minttl: 60,
+		})
+		_ = w.WriteMsg(resp)
+	})
+
+	dialer := New(
+		WithResolvers(addr),
+		WithCache(10, time.Second, time.Minute),
+		WithNegativeCache(0, time.Minute),
+		WithQueryTypes(TypeA),
+	)
+	defer dialer.Close()
+
+	_, err := dialer.lookupIPs(context.Background(), "missing.example.com")
+	var notFound *NotFoundError
+	require.ErrorAs(t, err, &notFound)
+	assert.False(t, notFound.Cached)
+
+	// The answer is cached under a key with the record type added, which must not end
+	// up in the error
+	_, err = dialer.lookupIPs(context.Background(), "missing.example.com")
+	require.ErrorAs(t, err, &notFound)
+	assert.True(t, notFound.Cached)
+	assert.Equal(t, "missing.example.com", notFound.Host)
+	assert.Equal(t, int32(1), queries.Load())
+}
+
+func TestDialer_NegativeCache_NoSOA(t *testing.T) {
+	var queries atomic.Int32
+	addr := startTestDNSServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		queries.Add(1)
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		_ = w.WriteMsg(resp)
+	})
+
+	dialer := New(
+		WithResolvers(addr),
+		WithCache(10, time.Second, time.Minute),
+		WithNegativeCache(0, time.Minute),
+	)
+	defer dialer.Close()
+
+	// NODATA without an SOA says nothing about how long it holds, so it isn't cached
+	for i := 0; i < 2; i++ {
+		_, err := dialer.lookupIPs(context.Background(), "example.com")
+		var notFound *NotFoundError
+		require.ErrorAs(t, err, &notFound)
+		assert.False(t, notFound.NXDomain)
+		assert.False(t, notFound.Cached)
+	}
+	assert.Equal(t, int32(4), queries.Load())
+}
@@ -0,0 +1,152 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// defaultMaxCNAMEDepth is how many CNAMEs we follow before giving up, unless configured
+// otherwise with WithMaxCNAMEDepth. Real-world chains (e.g. through a couple of CDNs)
+// rarely go beyond 3 or 4.
+const defaultMaxCNAMEDepth = 8
+
+// cnameChaser wraps a resolver and follows CNAME chains to the records that were actually
+// asked for.
+//
+// A recursive resolver normally answers a question for an alias with the whole chain: the
+// CNAME records followed by the records of the name they lead to. Not all of them do, some
+// return only the first CNAME, or part of the chain, and leave it to us to follow the rest.
+// Without chasing, such an answer has no records of the requested type at all.
+//
+// The wrapper returns only the terminal records, the ones of the requested type at the end
+// of the chain. That way strategies like Consensus and Compare compare what the chain
+// resolved to, rather than whatever part of the chain each resolver happened to include.
+// The terminal records' Name is the canonical name of the host.
+type cnameChaser struct {
+	resolver Resolver
+
+	// maxDepth is the maximum number of CNAMEs to follow
+	maxDepth int
+}
+
+func (c *cnameChaser) ResolveType(ctx context.Context, host string, qtype RecordType) ([]Record, error) {
+	// A question for the CNAME itself has nothing to chase
+	if qtype == TypeCNAME {
+		return c.resolver.ResolveType(ctx, host, qtype)
+	}
+
+	name := dns.Fqdn(host)
+	records, err := c.resolver.ResolveType(ctx, host, qtype)
+	if err != nil {
+		return nil, err
+	}
+	records = withOwner(records, name)
+	visited := map[string]bool{dns.CanonicalName(name): true}
+
+	// Records are only cached as long as the shortest TTL in the chain, once any of the
+	// CNAMEs expires the chain may lead somewhere else
+	var chainTTL uint32
+	first := true
+	depth := 0
+
+	for {
+		if terminal := recordsFor(records, name, qtype); len(terminal) > 0 {
+			if !first {
+				for i := range terminal {
+					terminal[i].TTL = min(terminal[i].TTL, chainTTL)
+				}
+			}
+			return terminal, nil
+		}
+
+		cname, ok := cnameFor(records, name)
+		if !ok {
+			// Neither the records nor a CNAME to follow, there's nothing more to find
+			return nil, &NotFoundError{Host: strings.TrimSuffix(name, ".")}
+		}
+
+		depth++
+		if depth > c.maxDepth {
+			return nil, fmt.Errorf("CNAME chain for %s is longer than %d", host, c.maxDepth)
+		}
+
+		target := dns.Fqdn(cname.Value)
+		if visited[dns.CanonicalName(target)] {
+			return nil, fmt.Errorf("CNAME loop for %s at %s", host, target)
+		}
+		visited[dns.CanonicalName(target)] = true
+
+		if first || cname.TTL < chainTTL {
+			chainTTL = cname.TTL
+		}
+		first = false
+		name = target
+
+		// The resolver included the rest of the chain in its answer, keep following it there.
+		// Otherwise we have to ask for the target ourselves.
+		if len(recordsFor(records, name, qtype)) > 0 {
+			continue
+		}
+		if _, ok := cnameFor(records, name); ok {
+			continue
+		}
+
+		records, err = c.resolver.ResolveType(ctx, strings.TrimSuffix(name, "."), qtype)
+		if err != nil {
+			return nil, err
+		}
+		records = withOwner(records, name)
+	}
+}
+
+func (c *cnameChaser) Name() string {
+	return c.resolver.Name()
+}
+
+// withOwner sets the owner name of records that don't have one, e.g. from custom resolvers
+// that don't set it, to the name they were asked for. Records are copied rather than
+// modified, the resolver may hold on to them.
+func withOwner(records []Record, name string) []Record {
+	owned := make([]Record, len(records))
+	for i, record := range records {
+		if record.Name == "" {
+			record.Name = name
+		}
+		owned[i] = record
+	}
+	return owned
+}
+
+// recordsFor returns the records of qtype owned by name.
+func recordsFor(records []Record, name string, qtype RecordType) []Record {
+	var matching []Record
+	for _, record := range records {
+		if record.Type == qtype && ownedBy(record, name) {
+			matching = append(matching, record)
+		}
+	}
+	return matching
+}
+
+// cnameFor returns the CNAME record owned by name, if there is one.
+func cnameFor(records []Record, name string) (Record, bool) {
+	for _, record := range records {
+		if record.Type == TypeCNAME && ownedBy(record, name) {
+			return record, true
+		}
+	}
+	return Record{}, false
+}
+
+// ownedBy reports whether record belongs to name, comparing names case-insensitively.
+func ownedBy(record Record, name string) bool {
+	return strings.EqualFold(dns.Fqdn(record.Name), name)
+}
@@ -0,0 +1,126 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chainResolver answers from a set of records like a server that doesn't follow CNAMEs: a
+// question for an alias gets only its CNAME record.
+type chainResolver struct {
+	name    string
+	records []Record
+
+	mu      sync.Mutex
+	queries []string
+}
+
+func (c *chainResolver) ResolveType(ctx context.Context, host string, qtype RecordType) ([]Record, error) {
+	c.mu.Lock()
+	c.queries = append(c.queries, host)
+	c.mu.Unlock()
+
+	if records := recordsFor(c.records, host+".", qtype); len(records) > 0 {
+		return records, nil
+	}
+	if cname, ok := cnameFor(c.records, host+"."); ok {
+		return []Record{cname}, nil
+	}
+	return nil, &NotFoundError{Host: host}
+}
+
+func (c *chainResolver) Name() string {
+	return c.name
+}
+
+func TestCNAMEChaser(t *testing.T) {
+	res := &chainResolver{records: []Record{
+		{Name: "www.example.com.", Type: TypeCNAME, Value: "cdn.example.net.", TTL: 300},
+		{Name: "cdn.example.net.", Type: TypeCNAME, Value: "edge.example.org.", TTL: 30},
+		{Name: "edge.example.org.", Type: TypeA, Value: "192.0.2.1", TTL: 600},
+	}}
+	chaser := &cnameChaser{resolver: res, maxDepth: defaultMaxCNAMEDepth}
+
+	records, err := chaser.ResolveType(context.Background(), "www.example.com", TypeA)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "edge.example.org.", records[0].Name)
+	assert.Equal(t, "192.0.2.1", records[0].Value)
+
+	// Capped at the shortest TTL in the chain
+	assert.Equal(t, uint32(30), records[0].TTL)
+	assert.Equal(t, []string{"www.example.com", "cdn.example.net", "edge.example.org"}, res.queries)
+}
+
+func TestCNAMEChaser_FullChain(t *testing.T) {
+	res := &mockResolver{response: []Record{
+		{Name: "www.example.com.", Type: TypeCNAME, Value: "example.com.", TTL: 300},
+		{Name: "example.com.", Type: TypeA, Value: "192.0.2.1", TTL: 60},
+	}}
+	chaser := &cnameChaser{resolver: res, maxDepth: defaultMaxCNAMEDepth}
+
+	// The whole chain is in the answer, only the terminal record is returned
+	records, err := chaser.ResolveType(context.Background(), "www.example.com", TypeA)
+	require.NoError(t, err)
+	assert.Equal(t, []Record{{Name: "example.com.", Type: TypeA, Value: "192.0.2.1", TTL: 60}}, records)
+}
+
+func TestCNAMEChaser_Errors(t *testing.T) {
+	loop := &chainResolver{records: []Record{
+		{Name: "a.example.com.", Type: TypeCNAME, Value: "b.example.com.", TTL: 60},
+		{Name: "b.example.com.", Type: TypeCNAME, Value: "A.example.com.", TTL: 60},
+	}}
+	_, err := (&cnameChaser{resolver: loop, maxDepth: defaultMaxCNAMEDepth}).ResolveType(context.Background(), "a.example.com", TypeA)
+	assert.EqualError(t, err, "CNAME loop for a.example.com at A.example.com.")
+
+	long := &chainResolver{records: []Record{
+		{Name: "a.example.com.", Type: TypeCNAME, Value: "b.example.com.", TTL: 60},
+		{Name: "b.example.com.", Type: TypeCNAME, Value: "c.example.com.", TTL: 60},
+		{Name: "c.example.com.", Type: TypeA, Value: "192.0.2.1", TTL: 60},
+	}}
+	_, err = (&cnameChaser{resolver: long, maxDepth: 1}).ResolveType(context.Background(), "a.example.com", TypeA)
+	assert.EqualError(t, err, "CNAME chain for a.example.com is longer than 1")
+
+	dangling := &chainResolver{records: []Record{
+		{Name: "a.example.com.", Type: TypeCNAME, Value: "gone.example.com.", TTL: 60},
+	}}
+	_, err = (&cnameChaser{resolver: dangling, maxDepth: 1}).ResolveType(context.Background(), "a.example.com", TypeA)
+	var notFound *NotFoundError
+	assert.ErrorAs(t, err, &notFound)
+}
+
+func TestDialer_CNAMEConsensus(t *testing.T) {
+	full := &mockResolver{name: "full", response: []Record{
+		{Name: "www.example.com.", Type: TypeCNAME, Value: "example.com.", TTL: 60},
+		{Name: "example.com.", Type: TypeA, Value: "192.0.2.1", TTL: 60},
+	}}
+	partial := &chainResolver{name: "partial", records: []Record{
+		{Name: "www.example.com.", Type: TypeCNAME, Value: "example.com.", TTL: 60},
+		{Name: "example.com.", Type: TypeA, Value: "192.0.2.1", TTL: 60},
+	}}
+
+	dialer := New(
+		WithCustomResolvers(full, partial),
+		WithStrategy(Consensus{MinAgreement: 2}),
+	)
+	defer dialer.Close()
+
+	// The resolvers agree on where the chain leads, even though their answers differ
+	ips, err := dialer.LookupIP(context.Background(), "ip4", "www.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []net.IP{net.ParseIP("192.0.2.1")}, ips)
+
+	cname, err := dialer.LookupCNAME(context.Background(), "www.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "example.com.", cname)
+}
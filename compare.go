@@ -9,8 +9,9 @@ import (
 	"context"
 )
 
-func (s Compare) ResolveType(ctx context.Context, host string, qtype RecordType, resolvers []resolver, logger Logger) ([]Record, error) {
+func (s Compare) ResolveType(ctx context.Context, host string, qtype RecordType, resolvers []Resolver, logger Logger) ([]Record, error) {
 	results := make(map[string][]Record)
+	var errs []*ResolverError
 
 	// Query all resolvers and collect successful responses. Unlike Consensus,
 	// we don't group by equality yet - we keep track of which resolver returned what
@@ -19,9 +20,12 @@ func (s Compare) ResolveType(ctx context.Context, host string, qtype RecordType,
 		records, err := res.ResolveType(ctx, host, qtype)
 		if err == nil {
 			results[res.Name()] = records
+			continue
 		}
 		// Ignore errors - Compare is typically used for integrity checking,
-		// so we work with whatever responses we get.
+		// so we work with whatever responses we get. We only hold on to them in
+		// case no resolver answers at all.
+		errs = append(errs, &ResolverError{Resolver: res.Name(), Err: err})
 	}
 
 	// Check if all successful responses agree. Use the first result as the baseline
@@ -59,6 +63,9 @@ func (s Compare) ResolveType(ctx context.Context, host string, qtype RecordType,
 	// Compare is about detecting differences, not blocking on them. If you need
 	// to block on discrepancies, use Consensus instead.
 	if first == nil {
+		if len(errs) > 0 {
+			return nil, &AggregateError{Host: host, Type: qtype, Errors: errs}
+		}
 		return nil, nil
 	}
 	return first, nil
@@ -19,6 +19,11 @@ import (
 //
 // Concurrency: The pool is safe for concurrent access. Multiple goroutines can
 // Get and Put connections simultaneously.
+//
+// Since every in-flight query holds a socket of its own, the pool creates and closes
+// sockets beyond 'size' under high load. udpResolver used to work this way, it now
+// multiplexes queries over a few sockets through pipelinePool instead. connPool is only
+// kept as the baseline that design is benchmarked against (see BenchmarkUDPTransport_*).
 type connPool struct {
 	// addr is the DNS resolver address we're pooling connections for (e.g., "8.8.8.8:53")
 	addr string
@@ -7,10 +7,11 @@ package dnsdialer
 
 import (
 	"context"
+	"errors"
 	"fmt"
 )
 
-func (s Consensus) ResolveType(ctx context.Context, host string, qtype RecordType, resolvers []resolver, logger Logger) ([]Record, error) {
+func (s Consensus) ResolveType(ctx context.Context, host string, qtype RecordType, resolvers []Resolver, logger Logger) ([]Record, error) {
 	// Default to simple majority if not specified. For 3 resolvers, we need 2 to agree.
 	// For 4 resolvers, we need 3. This provides Byzantine fault tolerance, assuming
 	// at most (n-1)/2 resolvers are compromised or malfunctioning.
@@ -24,6 +25,11 @@ func (s Consensus) ResolveType(ctx context.Context, host string, qtype RecordTyp
 	}
 
 	var groups []resultGroup
+	var errs []*ResolverError
+
+	// Resolvers answering that the name doesn't exist, or has no records of this type,
+	// agree with each other too. Keyed by NXDomain, so NXDOMAIN and NODATA are separate.
+	notFound := make(map[bool][]*NotFoundError)
 
 	// Query all resolvers and group responses by equality. We don't race or short-circuit
 	// here because we need to collect enough responses to reach consensus. This is inherently
@@ -34,6 +40,11 @@ func (s Consensus) ResolveType(ctx context.Context, host string, qtype RecordTyp
 			// Skip failed queries. Note that if too many fail, we won't reach consensus.
 			// For example, with 3 resolvers and MinAgreement=2, if one fails we can still
 			// succeed if the other 2 agree. But if 2 fail, we'll always fail.
+			errs = append(errs, &ResolverError{Resolver: res.Name(), Err: err})
+			var nf *NotFoundError
+			if errors.As(err, &nf) {
+				notFound[nf.NXDomain] = append(notFound[nf.NXDomain], nf)
+			}
 			continue
 		}
 
@@ -71,9 +82,31 @@ func (s Consensus) ResolveType(ctx context.Context, host string, qtype RecordTyp
 		}
 	}
 
+	// Enough resolvers agreeing that there is nothing to return is a consensus as well, and
+	// a negative answer we can trust.
+	for _, nxdomain := range []bool{true, false} {
+		if answers := notFound[nxdomain]; len(answers) >= s.MinAgreement {
+			logger.Debug("consensus reached on negative answer",
+				Field{"agreements", len(answers)},
+				Field{"required", s.MinAgreement},
+				Field{"type", qtype.String()})
+			var merged *NotFoundError
+			for _, nf := range answers {
+				merged = mergeNotFound(merged, nf)
+			}
+			return nil, merged
+		}
+	}
+
 	// No consensus reached. This could mean:
 	// 1. Too many resolvers failed to respond
 	// 2. Resolvers returned different data and no group reached MinAgreement
 	// 3. Active DNS poisoning attack with responses split across multiple values
-	return nil, fmt.Errorf("consensus not reached: required %d agreements", s.MinAgreement)
+	//
+	// If some resolvers failed, their errors are included to help tell these apart.
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%w: required %d agreements: %w", ErrConsensusNotReached, s.MinAgreement,
+			&AggregateError{Host: host, Type: qtype, Errors: errs})
+	}
+	return nil, fmt.Errorf("%w: required %d agreements", ErrConsensusNotReached, s.MinAgreement)
 }
@@ -0,0 +1,214 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// dohMediaType is the media type for DNS wire-format messages (RFC 8484, Section 6).
+const dohMediaType = "application/dns-message"
+
+// dohResolver implements the Resolver interface using DNS-over-HTTPS (RFC 8484).
+//
+// Queries are sent in wire format, either as a base64url-encoded GET parameter (the
+// default, since GET responses are cacheable by HTTP intermediaries) or as a POST body.
+// Connection reuse is left to the http.Client: the default transport negotiates HTTP/2,
+// which multiplexes concurrent queries over a single connection.
+type dohResolver struct {
+	// url is the DoH endpoint (e.g., "https://dns.google/dns-query")
+	url string
+
+	// timeout is the default timeout we use if the context has no deadline set
+	timeout time.Duration
+
+	// client sends the HTTP requests, pluggable so users can control proxies, TLS, etc.
+	client *http.Client
+
+	// ownsClient is set if we created client ourselves. A client passed in by the user may
+	// be shared with the rest of their application, so we leave its connections alone.
+	ownsClient bool
+
+	// usePOST sends queries as POST bodies instead of GET parameters
+	usePOST bool
+}
+
+func newDoHResolver(url string, cfg DoHConfig, timeout time.Duration, poolSize int) *dohResolver {
+	client, ownsClient := cfg.Client, false
+	if client == nil {
+		ownsClient = true
+		client = &http.Client{
+			Transport: &http.Transport{
+				// We set a custom transport, so HTTP/2 has to be requested explicitly
+				ForceAttemptHTTP2:   true,
+				MaxIdleConnsPerHost: poolSize,
+				IdleConnTimeout:     90 * time.Second,
+				TLSHandshakeTimeout: timeout,
+			},
+		}
+	}
+
+	return &dohResolver{
+		url:        url,
+		timeout:    timeout,
+		client:     client,
+		ownsClient: ownsClient,
+		usePOST:    cfg.UsePOST,
+	}
+}
+
+func (r *dohResolver) ResolveType(ctx context.Context, host string, qtype RecordType) ([]Record, error) {
+	response, err := r.exchange(ctx, newQuery(host, qtype))
+	if err != nil {
+		return nil, err
+	}
+	return parseResponse(response)
+}
+
+// exchange sends msg to the DoH endpoint and returns the response.
+func (r *dohResolver) exchange(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	// Unlike the other transports, HTTP has no deadline of its own here, so apply the
+	// resolver's default timeout if the caller didn't set one.
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+
+	// RFC 8484 recommends a message ID of 0, so identical queries produce identical
+	// requests that HTTP caches can answer. HTTP already matches responses to requests,
+	// so the ID has no other purpose here.
+	query := msg.Copy()
+	query.Id = 0
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack query: %w", err)
+	}
+
+	var req *http.Request
+	if r.usePOST {
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, r.url, bytes.NewReader(packed))
+		if err == nil {
+			req.Header.Set("Content-Type", dohMediaType)
+		}
+	} else {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+		if err == nil {
+			q := req.URL.Query()
+			q.Set("dns", base64.RawURLEncoding.EncodeToString(packed))
+			req.URL.RawQuery = q.Encode()
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", dohMediaType)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// Drain a bit of the body so the connection can be reused
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		return nil, &HTTPStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != dohMediaType {
+		return nil, fmt.Errorf("unexpected content type %q", resp.Header.Get("Content-Type"))
+	}
+
+	// A DNS message can't be larger than 64KiB, don't let a misbehaving server make us
+	// read more than that.
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	response := new(dns.Msg)
+	if err := response.Unpack(body); err != nil {
+		return nil, fmt.Errorf("failed to unpack response: %w", err)
+	}
+
+	// Restore the caller's ID so the response looks like it would over any other transport
+	response.Id = msg.Id
+	return response, nil
+}
+
+func (r *dohResolver) Name() string {
+	return r.url
+}
+
+// Close closes idle connections held by the HTTP client, unless the client was passed in
+// through DoHConfig.
+func (r *dohResolver) Close() error {
+	if r.ownsClient {
+		r.client.CloseIdleConnections()
+	}
+	return nil
+}
+
+// HTTPStatusError is returned when a DoH server answers with a non-200 HTTP status.
+//
+// It matches the resolver errors the status stands for with errors.Is: 5xx and 429 match
+// ErrServFail, as the server is failing or overloaded right now, and 408 and 504 match
+// ErrTimeout. Other 4xx statuses mean the server won't ever accept the query as sent, e.g.
+// a wrong URL or a server that only supports GET, so they match neither, and Fallback
+// doesn't retry them.
+type HTTPStatusError struct {
+	// StatusCode is the HTTP status code, e.g. 503
+	StatusCode int
+
+	// Status is the status line, e.g. "503 Service Unavailable"
+	Status string
+}
+
+func (e *HTTPStatusError) Error() string {
+	// Translate the status codes RFC 8484 and common practice give meaning to, so the error
+	// says something more useful than just the number.
+	switch {
+	case e.StatusCode == http.StatusBadRequest:
+		return fmt.Sprintf("doh: server rejected query as malformed (%s)", e.Status)
+	case e.StatusCode == http.StatusRequestEntityTooLarge:
+		return fmt.Sprintf("doh: query too large (%s)", e.Status)
+	case e.StatusCode == http.StatusUnsupportedMediaType:
+		return fmt.Sprintf("doh: server doesn't accept %s (%s)", dohMediaType, e.Status)
+	case e.StatusCode == http.StatusTooManyRequests:
+		return fmt.Sprintf("doh: rate limited by server (%s)", e.Status)
+	case e.StatusCode >= 500:
+		return fmt.Sprintf("doh: server failure (%s)", e.Status)
+	default:
+		return fmt.Sprintf("doh: unexpected status (%s)", e.Status)
+	}
+}
+
+// Is makes the error match ErrServFail or ErrTimeout, depending on the status code.
+func (e *HTTPStatusError) Is(target error) bool {
+	switch target {
+	case ErrTimeout:
+		return e.StatusCode == http.StatusRequestTimeout || e.StatusCode == http.StatusGatewayTimeout
+	case ErrServFail:
+		return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// permanent reports whether the status means the query will keep failing no matter how
+// often it's sent, because the request itself or the endpoint is wrong.
+func (e *HTTPStatusError) permanent() bool {
+	return e.StatusCode >= 400 && e.StatusCode < 500 && !e.Is(ErrTimeout) && !e.Is(ErrServFail)
+}
@@ -0,0 +1,198 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startTestDoHServer runs a local HTTP/2 DoH server answering every A query with
+// 192.0.2.1. Every request's method is sent on the returned channel.
+func startTestDoHServer(t *testing.T) (*httptest.Server, <-chan string) {
+	t.Helper()
+
+	methods := make(chan string, 16)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods <- r.Method
+
+		var packed []byte
+		var err error
+		switch r.Method {
+		case http.MethodGet:
+			packed, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		case http.MethodPost:
+			if r.Header.Get("Content-Type") != dohMediaType {
+				w.WriteHeader(http.StatusUnsupportedMediaType)
+				return
+			}
+			packed, err = io.ReadAll(r.Body)
+		}
+
+		req := new(dns.Msg)
+		if err != nil || req.Unpack(packed) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP("192.0.2.1"),
+		})
+		out, _ := resp.Pack()
+		w.Header().Set("Content-Type", dohMediaType)
+		_, _ = w.Write(out)
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	return server, methods
+}
+
+func TestDoHResolver_GET(t *testing.T) {
+	server, methods := startTestDoHServer(t)
+
+	r := newDoHResolver(server.URL+"/dns-query", DoHConfig{Client: server.Client()}, time.Second, 2)
+	records, err := r.ResolveType(context.Background(), "example.com", TypeA)
+
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+	assert.Equal(t, "192.0.2.1", records[0].Value)
+	assert.Equal(t, http.MethodGet, <-methods)
+}
+
+func TestDoHResolver_POST(t *testing.T) {
+	server, methods := startTestDoHServer(t)
+
+	r := newDoHResolver(server.URL+"/dns-query", DoHConfig{Client: server.Client(), UsePOST: true}, time.Second, 2)
+	records, err := r.ResolveType(context.Background(), "example.com", TypeA)
+
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+	assert.Equal(t, http.MethodPost, <-methods)
+}
+
+// idleClosingTransport counts how often its idle connections were closed.
+type idleClosingTransport struct {
+	http.RoundTripper
+	closed atomic.Int32
+}
+
+func (t *idleClosingTransport) CloseIdleConnections() {
+	t.closed.Add(1)
+}
+
+func TestDoHResolver_CloseLeavesSuppliedClient(t *testing.T) {
+	server, _ := startTestDoHServer(t)
+	transport := &idleClosingTransport{RoundTripper: server.Client().Transport}
+
+	dialer := New(WithDoHResolversConfig(DoHConfig{Client: &http.Client{Transport: transport}}, server.URL+"/dns-query"))
+	_, err := dialer.Query(context.Background(), "example.com", TypeA)
+	require.NoError(t, err)
+
+	// The client may be shared with the rest of the application, its connections aren't ours
+	require.NoError(t, dialer.Close())
+	assert.Equal(t, int32(0), transport.closed.Load())
+}
+
+func TestDoHResolver_StatusCodes(t *testing.T) {
+	tests := []struct {
+		status   int
+		servFail bool
+		timeout  bool
+	}{
+		{status: http.StatusServiceUnavailable, servFail: true},
+		{status: http.StatusInternalServerError, servFail: true},
+		{status: http.StatusTooManyRequests, servFail: true},
+		{status: http.StatusGatewayTimeout, servFail: true, timeout: true},
+		{status: http.StatusRequestTimeout, timeout: true},
+		{status: http.StatusNotFound},
+		{status: http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			r := newDoHResolver(server.URL+"/dns-query", DoHConfig{Client: server.Client()}, time.Second, 2)
+			_, err := r.ResolveType(context.Background(), "example.com", TypeA)
+
+			var statusErr *HTTPStatusError
+			require.ErrorAs(t, err, &statusErr)
+			assert.Equal(t, tt.status, statusErr.StatusCode)
+			assert.Equal(t, tt.servFail, errors.Is(err, ErrServFail))
+			assert.Equal(t, tt.timeout, errors.Is(err, ErrTimeout))
+
+			dnsErr := newDNSError("example.com", &ResolverError{Resolver: r.Name(), Err: err})
+			assert.Equal(t, tt.timeout, dnsErr.IsTimeout)
+			assert.Equal(t, tt.servFail || tt.timeout, dnsErr.IsTemporary)
+		})
+	}
+}
+
+func TestFallback_RetriesDoHStatusErrors(t *testing.T) {
+	tests := []struct {
+		status   int
+		requests int32
+	}{
+		{status: http.StatusServiceUnavailable, requests: 3},
+		{status: http.StatusGatewayTimeout, requests: 3},
+		{status: http.StatusRequestTimeout, requests: 3},
+		{status: http.StatusNotFound, requests: 1},
+		{status: http.StatusBadRequest, requests: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			var requests atomic.Int32
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			resolvers := []Resolver{
+				newDoHResolver(server.URL+"/dns-query", DoHConfig{Client: server.Client()}, time.Second, 2),
+			}
+			_, err := Fallback{Attempts: 3}.ResolveType(context.Background(), "example.com", TypeA, resolvers, &mockLogger{})
+
+			assert.Error(t, err)
+			assert.Equal(t, tt.requests, requests.Load())
+		})
+	}
+}
+
+func TestDoHResolver_Race(t *testing.T) {
+	server, _ := startTestDoHServer(t)
+
+	resolvers := []Resolver{
+		&mockResolver{name: "broken", err: assert.AnError},
+		newDoHResolver(server.URL+"/dns-query", DoHConfig{Client: server.Client()}, time.Second, 2),
+	}
+
+	records, err := Race{}.ResolveType(context.Background(), "example.com", TypeA, resolvers, &mockLogger{})
+
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+}
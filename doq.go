@@ -0,0 +1,254 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+// DoQ error codes used when closing connections and resetting streams (RFC 9250, Section 4.3).
+const (
+	doqNoError          = 0x0
+	doqRequestCancelled = 0x3
+)
+
+// doqResolver implements the Resolver interface using DNS-over-QUIC (RFC 9250).
+//
+// A single QUIC connection per resolver is kept open and every query gets its own
+// bidirectional stream, so a lost packet only stalls the query it belongs to instead
+// of every query behind it like with TCP. Reconnects use 0-RTT when the server allows
+// it, which lets the first query go out together with the handshake.
+type doqResolver struct {
+	// addr is the DNS resolver address with port (e.g., "94.140.14.140:853")
+	addr string
+
+	// timeout is the default timeout we use if the context has no deadline set
+	timeout time.Duration
+
+	// tlsConfig is used for every connection, its session cache enables 0-RTT on reconnect
+	tlsConfig *tls.Config
+
+	// quicConfig holds the QUIC transport parameters we dial with
+	quicConfig *quic.Config
+
+	// mu protects conn and closed
+	mu sync.Mutex
+
+	// conn is the current QUIC connection, replaced once the server closes it
+	conn *quic.Conn
+
+	// closed is set to true when the resolver is closed, prevents new connections
+	closed bool
+}
+
+func newDoQResolver(addr string, tlsConfig *tls.Config, timeout time.Duration) *doqResolver {
+	// DoQ uses UDP port 853, the same port number as DNS-over-TLS over TCP (RFC 9250, Section 4.1.1)
+	addr = withDefaultPort(addr, "853")
+
+	// Clone so we never mutate a config the caller might share with other clients
+	cfg := &tls.Config{}
+	if tlsConfig != nil {
+		cfg = tlsConfig.Clone()
+	}
+	if cfg.ServerName == "" {
+		host, _, _ := net.SplitHostPort(addr)
+		cfg.ServerName = host
+	}
+
+	// The "doq" ALPN token is mandatory, servers reject connections without it
+	cfg.NextProtos = []string{"doq"}
+
+	// Session tickets are what make 0-RTT possible on subsequent connections
+	if cfg.ClientSessionCache == nil {
+		cfg.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+
+	// QUIC requires TLS 1.3
+	cfg.MinVersion = tls.VersionTLS13
+
+	return &doqResolver{
+		addr:      addr,
+		timeout:   timeout,
+		tlsConfig: cfg,
+		quicConfig: &quic.Config{
+			HandshakeIdleTimeout: timeout,
+			MaxIdleTimeout:       defaultIdleTimeout,
+		},
+	}
+}
+
+func (r *doqResolver) ResolveType(ctx context.Context, host string, qtype RecordType) ([]Record, error) {
+	response, err := r.exchange(ctx, newQuery(host, qtype))
+	if err != nil {
+		return nil, err
+	}
+	return parseResponse(response)
+}
+
+// exchange sends msg on a new stream of the shared QUIC connection and returns the response.
+func (r *doqResolver) exchange(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	// We prefer the context deadline if set, otherwise use the resolver's default timeout.
+	// It also bounds dialing and waiting for a stream, which blocks for as long as the
+	// server's stream limit is reached.
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(r.timeout)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	// Like with pipelined TCP, the server may have closed the connection since we last
+	// used it. Opening a stream on a dead connection fails right away, so retry once on
+	// a fresh connection in that case.
+	for {
+		conn, fresh, err := r.getConn(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		stream, err := conn.OpenStreamSync(ctx)
+		if err != nil {
+			// Waiting for a stream usually ends because the caller gave up while the
+			// server's stream limit was reached. The connection is fine then, and other
+			// queries are still using it, so only drop it if it's actually gone.
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if conn.Context().Err() != nil {
+				r.dropConn(conn)
+				if !fresh {
+					continue
+				}
+			}
+			return nil, fmt.Errorf("failed to open stream: %w", err)
+		}
+
+		return r.exchangeStream(ctx, stream, msg, deadline)
+	}
+}
+
+// exchangeStream runs a single query over its own stream.
+func (r *doqResolver) exchangeStream(ctx context.Context, stream *quic.Stream, msg *dns.Msg, deadline time.Time) (*dns.Msg, error) {
+	_ = stream.SetDeadline(deadline)
+
+	// If the caller gives up, tell the server right away instead of letting the stream
+	// linger until the deadline (RFC 9250, Section 4.3.1).
+	stop := context.AfterFunc(ctx, func() {
+		stream.CancelWrite(doqRequestCancelled)
+		stream.CancelRead(doqRequestCancelled)
+	})
+	defer stop()
+
+	// The message ID must be 0 in DoQ, streams already tell queries apart (RFC 9250, Section 4.2.1)
+	query := msg.Copy()
+	query.Id = 0
+	packed, err := query.Pack()
+	if err != nil {
+		stream.CancelWrite(doqRequestCancelled)
+		stream.CancelRead(doqRequestCancelled)
+		return nil, fmt.Errorf("failed to pack query: %w", err)
+	}
+
+	// Messages are prefixed with a 2-byte length field, the same as DNS over TCP
+	buf := make([]byte, 2+len(packed))
+	binary.BigEndian.PutUint16(buf, uint16(len(packed)))
+	copy(buf[2:], packed)
+	if _, err := stream.Write(buf); err != nil {
+		stream.CancelRead(doqRequestCancelled)
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+
+	// Closing the stream only closes our sending direction. The server needs to see the
+	// FIN to know the query is complete, and we can keep reading the response.
+	if err := stream.Close(); err != nil {
+		stream.CancelRead(doqRequestCancelled)
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+
+	var length [2]byte
+	if _, err := io.ReadFull(stream, length[:]); err != nil {
+		stream.CancelRead(doqRequestCancelled)
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(stream, body); err != nil {
+		stream.CancelRead(doqRequestCancelled)
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+
+	response := new(dns.Msg)
+	if err := response.Unpack(body); err != nil {
+		return nil, fmt.Errorf("failed to unpack response: %w", err)
+	}
+
+	// Restore the caller's ID so the response looks like it would over any other transport
+	response.Id = msg.Id
+	return response, nil
+}
+
+// getConn returns the current QUIC connection, dialing a new one if there is none or
+// the previous one was closed. The returned bool reports whether it was freshly dialed.
+func (r *doqResolver) getConn(ctx context.Context) (*quic.Conn, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil, false, net.ErrClosed
+	}
+
+	if r.conn != nil && r.conn.Context().Err() == nil {
+		return r.conn, false, nil
+	}
+
+	// DialAddrEarly returns as soon as 0-RTT data can be sent, so with a resumed session
+	// the first query doesn't wait for the handshake to finish.
+	conn, err := quic.DialAddrEarly(ctx, r.addr, r.tlsConfig, r.quicConfig)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	r.conn = conn
+	return conn, true, nil
+}
+
+// dropConn forgets conn if it is still the current connection, so the next query dials a new one.
+func (r *doqResolver) dropConn(conn *quic.Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.conn == conn {
+		_ = conn.CloseWithError(doqNoError, "")
+		r.conn = nil
+	}
+}
+
+func (r *doqResolver) Name() string {
+	return "quic://" + r.addr
+}
+
+// Close closes the QUIC connection. Queries in flight fail.
+func (r *doqResolver) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closed = true
+	if r.conn != nil {
+		_ = r.conn.CloseWithError(doqNoError, "")
+		r.conn = nil
+	}
+	return nil
+}
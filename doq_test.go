@@ -0,0 +1,203 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startTestDoQServer runs a local DNS-over-QUIC server answering every A query with
+// 192.0.2.1. It returns the server address, a TLS config that trusts it, and a counter
+// of accepted connections.
+func startTestDoQServer(t *testing.T) (string, *tls.Config, *atomic.Int32) {
+	return startTestDoQServerWith(t, nil, serveDoQStream)
+}
+
+// startTestDoQServerWith is like startTestDoQServer, but with the given QUIC config and
+// stream handler.
+func startTestDoQServerWith(t *testing.T, quicConfig *quic.Config, handler func(*quic.Stream)) (string, *tls.Config, *atomic.Int32) {
+	t.Helper()
+
+	cert, roots := testCertificate(t)
+	l, err := quic.ListenAddr("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"doq"},
+	}, quicConfig)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+
+	var conns atomic.Int32
+	go func() {
+		for {
+			conn, err := l.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			conns.Add(1)
+			go func() {
+				for {
+					stream, err := conn.AcceptStream(context.Background())
+					if err != nil {
+						return
+					}
+					go handler(stream)
+				}
+			}()
+		}
+	}()
+
+	return l.Addr().String(), &tls.Config{RootCAs: roots}, &conns
+}
+
+func serveDoQStream(stream *quic.Stream) {
+	defer stream.Close()
+
+	// The query is complete once the client closes its side of the stream
+	data, err := io.ReadAll(stream)
+	if err != nil || len(data) < 2 {
+		return
+	}
+	req := new(dns.Msg)
+	if err := req.Unpack(data[2:]); err != nil || req.Id != 0 {
+		stream.CancelWrite(0x2) // DOQ_PROTOCOL_ERROR
+		return
+	}
+
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.Answer = append(resp.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   net.ParseIP("192.0.2.1"),
+	})
+	packed, _ := resp.Pack()
+	buf := make([]byte, 2+len(packed))
+	binary.BigEndian.PutUint16(buf, uint16(len(packed)))
+	copy(buf[2:], packed)
+	_, _ = stream.Write(buf)
+}
+
+func TestDoQResolver_ResolveType(t *testing.T) {
+	addr, cfg, _ := startTestDoQServer(t)
+
+	r := newDoQResolver(addr, cfg, time.Second)
+	defer r.Close()
+
+	records, err := r.ResolveType(context.Background(), "example.com", TypeA)
+
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+	assert.Equal(t, "192.0.2.1", records[0].Value)
+	assert.Equal(t, "quic://"+addr, r.Name())
+}
+
+func TestDoQResolver_ReusesConnection(t *testing.T) {
+	addr, cfg, conns := startTestDoQServer(t)
+
+	r := newDoQResolver(addr, cfg, time.Second)
+	defer r.Close()
+
+	// Make sure the connection exists before the burst, so concurrent queries don't
+	// race to be the first one
+	_, err := r.ResolveType(context.Background(), "example.com", TypeA)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := r.ResolveType(context.Background(), "example.com", TypeA)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), conns.Load())
+}
+
+func TestDoQResolver_Reconnects(t *testing.T) {
+	addr, cfg, conns := startTestDoQServer(t)
+
+	r := newDoQResolver(addr, cfg, time.Second)
+	defer r.Close()
+
+	_, err := r.ResolveType(context.Background(), "example.com", TypeA)
+	require.NoError(t, err)
+
+	// Simulate the server going away between queries
+	r.mu.Lock()
+	_ = r.conn.CloseWithError(doqNoError, "")
+	r.mu.Unlock()
+
+	_, err = r.ResolveType(context.Background(), "example.com", TypeA)
+
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), conns.Load())
+}
+
+func TestDoQResolver_CancelledQueryKeepsConnection(t *testing.T) {
+	addr, cfg, conns := startTestDoQServer(t)
+
+	r := newDoQResolver(addr, cfg, time.Second)
+	defer r.Close()
+
+	_, err := r.ResolveType(context.Background(), "example.com", TypeA)
+	require.NoError(t, err)
+
+	// E.g. a query that lost a race, the other queries on the connection must not notice
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = r.ResolveType(ctx, "example.com", TypeA)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	_, err = r.ResolveType(context.Background(), "example.com", TypeA)
+
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), conns.Load())
+}
+
+func TestDoQResolver_StreamLimitTimeout(t *testing.T) {
+	// The server never answers nor releases its only stream, so a second query has to
+	// wait for one
+	done := make(chan struct{})
+	addr, cfg, _ := startTestDoQServerWith(t, &quic.Config{MaxIncomingStreams: 1}, func(*quic.Stream) {
+		<-done
+	})
+	defer close(done)
+
+	r := newDoQResolver(addr, cfg, 200*time.Millisecond)
+	defer r.Close()
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := r.ResolveType(context.Background(), "example.com", TypeA)
+			errs <- err
+		}()
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			assert.Error(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("query waiting for a stream ignored the resolver timeout")
+		}
+	}
+}
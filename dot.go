@@ -0,0 +1,157 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// dotResolver implements the Resolver interface using DNS-over-TLS (RFC 7858).
+//
+// DNS-over-TLS uses the same length-prefixed framing as TCP, just wrapped in a TLS
+// session, so we reuse the pipelined connection pool. Connections are persistent and
+// TLS sessions are resumed when a connection has to be re-established, which keeps
+// the handshake cost off the hot path.
+type dotResolver struct {
+	// addr is the DNS resolver address with port (e.g., "1.1.1.1:853")
+	addr string
+
+	// timeout is the default timeout we use if the context has no deadline set
+	timeout time.Duration
+
+	// pool holds the pipelined TLS connections we send queries over
+	pool *pipelinePool
+}
+
+func newDoTResolver(addr string, tlsConfig *tls.Config, timeout time.Duration, poolSize int) *dotResolver {
+	// Port 853 is the well-known port for DNS-over-TLS (RFC 7858, Section 3.1)
+	addr = withDefaultPort(addr, "853")
+
+	// Clone so we never mutate a config the caller might share with other clients
+	cfg := &tls.Config{}
+	if tlsConfig != nil {
+		cfg = tlsConfig.Clone()
+	}
+
+	// Without an explicit server name, verify the certificate against whatever the user
+	// put in the address. For IP addresses this requires an IP SAN in the certificate,
+	// which the large public resolvers (1.1.1.1, 8.8.8.8, 9.9.9.9) all have.
+	if cfg.ServerName == "" {
+		host, _, _ := net.SplitHostPort(addr)
+		cfg.ServerName = host
+	}
+
+	// Session resumption lets reconnects skip the full handshake after the server closes
+	// an idle connection, which happens regularly with DNS-over-TLS.
+	if cfg.ClientSessionCache == nil {
+		cfg.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+
+	// RFC 8310 requires TLS 1.2 or later for DNS privacy profiles
+	if cfg.MinVersion == 0 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: timeout},
+		Config:    cfg,
+	}
+	dial := func(ctx context.Context) (net.Conn, error) {
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+
+	return &dotResolver{
+		addr:    addr,
+		timeout: timeout,
+		pool:    newPipelinePool(dial, timeout, poolSize),
+	}
+}
+
+func (r *dotResolver) ResolveType(ctx context.Context, host string, qtype RecordType) ([]Record, error) {
+	response, err := r.exchange(ctx, newQuery(host, qtype))
+	if err != nil {
+		return nil, err
+	}
+	return parseResponse(response)
+}
+
+// exchange sends msg over one of the pooled TLS connections and returns the response.
+func (r *dotResolver) exchange(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	// We prefer the context deadline if set, otherwise use the resolver's default timeout
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(r.timeout)
+	}
+
+	return r.pool.exchange(ctx, msg, deadline)
+}
+
+func (r *dotResolver) Name() string {
+	return "tls://" + r.addr
+}
+
+// discardedResponses returns the number of responses dropped because they didn't match
+// any query in flight.
+func (r *dotResolver) discardedResponses() uint64 {
+	return r.pool.discarded.Load()
+}
+
+// Close closes all pooled connections.
+func (r *dotResolver) Close() error {
+	return r.pool.Close()
+}
+
+// errSPKIPinMismatch is returned when none of the server's certificates match a configured pin.
+var errSPKIPinMismatch = errors.New("tls: no certificate matches the configured SPKI pins")
+
+// VerifySPKIPins returns a function suitable for tls.Config.VerifyConnection that accepts
+// the connection only if one of the certificates presented by the server matches one of
+// the given pins.
+//
+// Each pin is the base64-encoded SHA-256 digest of a certificate's DER-encoded
+// SubjectPublicKeyInfo, the format used by RFC 7858 (Section 4.2) and RFC 7469. The pin
+// for a server can be computed with:
+//
+//	openssl s_client -connect 1.1.1.1:853 </dev/null 2>/dev/null | \
+//	    openssl x509 -pubkey -noout | \
+//	    openssl pkey -pubin -outform der | \
+//	    openssl dgst -sha256 -binary | base64
+//
+// Pinning runs in addition to regular certificate verification. To rely on pins alone,
+// for example with a self-signed certificate, also set InsecureSkipVerify.
+//
+// Example:
+//
+//	dialer := New(
+//	    WithDoTResolversTLS(&tls.Config{
+//	        ServerName:       "cloudflare-dns.com",
+//	        VerifyConnection: VerifySPKIPins("<base64 sha256 pin>"),
+//	    }, "1.1.1.1:853"),
+//	)
+func VerifySPKIPins(pins ...string) func(tls.ConnectionState) error {
+	pinSet := make(map[string]struct{}, len(pins))
+	for _, pin := range pins {
+		pinSet[pin] = struct{}{}
+	}
+
+	return func(cs tls.ConnectionState) error {
+		for _, cert := range cs.PeerCertificates {
+			digest := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			if _, ok := pinSet[base64.StdEncoding.EncodeToString(digest[:])]; ok {
+				return nil
+			}
+		}
+		return errSPKIPinMismatch
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCertificate borrows the self-signed certificate httptest uses for its TLS servers,
+// which is valid for 127.0.0.1 and "example.com".
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	return server.TLS.Certificates[0], roots
+}
+
+// startTestDoTServer runs a local DNS-over-TLS server answering every A query with
+// 192.0.2.1, and returns its address along with a pool that trusts its certificate.
+func startTestDoTServer(t *testing.T) (string, *x509.CertPool) {
+	t.Helper()
+
+	cert, roots := testCertificate(t)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	require.NoError(t, err)
+
+	started := make(chan struct{})
+	server := &dns.Server{
+		Listener: l,
+		Net:      "tcp-tls",
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			resp := new(dns.Msg)
+			resp.SetReply(req)
+			resp.Answer = append(resp.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.ParseIP("192.0.2.1"),
+			})
+			_ = w.WriteMsg(resp)
+		}),
+		NotifyStartedFunc: func() { close(started) },
+	}
+	go func() { _ = server.ActivateAndServe() }()
+	<-started
+	t.Cleanup(func() { _ = server.Shutdown() })
+
+	return l.Addr().String(), roots
+}
+
+func TestDoTResolver_ResolveType(t *testing.T) {
+	addr, roots := startTestDoTServer(t)
+
+	r := newDoTResolver(addr, &tls.Config{RootCAs: roots}, time.Second, 2)
+	defer r.Close()
+
+	records, err := r.ResolveType(context.Background(), "example.com", TypeA)
+
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+	assert.Equal(t, "192.0.2.1", records[0].Value)
+	assert.Equal(t, "tls://"+addr, r.Name())
+}
+
+func TestDoTResolver_UntrustedCertificate(t *testing.T) {
+	addr, _ := startTestDoTServer(t)
+
+	r := newDoTResolver(addr, nil, time.Second, 2)
+	defer r.Close()
+
+	_, err := r.ResolveType(context.Background(), "example.com", TypeA)
+
+	assert.Error(t, err)
+}
+
+func TestDoTResolver_SPKIPins(t *testing.T) {
+	addr, roots := startTestDoTServer(t)
+	cert, _ := testCertificate(t)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	digest := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+	pin := base64.StdEncoding.EncodeToString(digest[:])
+
+	matching := newDoTResolver(addr, &tls.Config{RootCAs: roots, VerifyConnection: VerifySPKIPins(pin)}, time.Second, 2)
+	defer matching.Close()
+	_, err = matching.ResolveType(context.Background(), "example.com", TypeA)
+	assert.NoError(t, err)
+
+	mismatching := newDoTResolver(addr, &tls.Config{RootCAs: roots, VerifyConnection: VerifySPKIPins("bm90IGEgcGlu")}, time.Second, 2)
+	defer mismatching.Close()
+	_, err = mismatching.ResolveType(context.Background(), "example.com", TypeA)
+	assert.ErrorIs(t, err, errSPKIPinMismatch)
+}
@@ -0,0 +1,244 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Sentinel errors for the ways resolution can fail. Errors returned by the Dialer, its
+// strategies and the built-in resolvers match them with errors.Is, e.g.
+//
+//	if errors.Is(err, dnsdialer.ErrNXDomain) {
+//	    // the host doesn't exist
+//	}
+//
+// The concrete error types, NotFoundError, RcodeError, HTTPStatusError, ResolverError and
+// AggregateError, carry the details and can be inspected with errors.As.
+var (
+	// ErrNXDomain means the name doesn't exist, matched by a NotFoundError with NXDomain set.
+	ErrNXDomain = errors.New("no such host")
+
+	// ErrNoData means the name exists but has no records of the requested type, matched
+	// by a NotFoundError without NXDomain set.
+	ErrNoData = errors.New("no records found")
+
+	// ErrServFail means the server failed to answer the query, matched by an RcodeError
+	// with the SERVFAIL response code, or an HTTPStatusError with a 5xx or 429 status.
+	ErrServFail = errors.New("server failure")
+
+	// ErrTimeout means a resolver didn't answer in time, matched by a ResolverError whose
+	// error is a timeout, or an HTTPStatusError with a 408 or 504 status.
+	ErrTimeout = errors.New("timeout")
+
+	// ErrConsensusNotReached means not enough resolvers agreed on an answer for the
+	// Consensus strategy.
+	ErrConsensusNotReached = errors.New("consensus not reached")
+)
+
+// NotFoundError is returned when a host has no records: either the name doesn't exist
+// at all (NXDOMAIN), or it exists but has no records of the requested type (NODATA).
+//
+// Unlike timeouts or server failures, this is a definitive answer from the DNS, so it's
+// worth caching (RFC 2308) and there's no point in asking other resolvers or retrying
+// right away. Use errors.As to tell it apart from other resolution failures:
+//
+//	var notFound *dnsdialer.NotFoundError
+//	if errors.As(err, &notFound) {
+//	    // host doesn't exist, don't retry
+//	}
+type NotFoundError struct {
+	// Host is the name that was looked up, without a trailing dot
+	Host string
+
+	// NXDomain is true if the name doesn't exist, false if it exists but has no records
+	// of the requested type (NODATA)
+	NXDomain bool
+
+	// Cached is true if the answer came from the negative cache rather than a resolver
+	Cached bool
+
+	// ttl is how long the answer may be cached, taken from the SOA record in the authority
+	// section. Zero means the response carried no SOA and must not be cached.
+	ttl time.Duration
+}
+
+func (e *NotFoundError) Error() string {
+	msg := "no such host " + e.Host
+	if !e.NXDomain {
+		msg = "no records found for " + e.Host
+	}
+	if e.Cached {
+		msg += " (cached)"
+	}
+	return msg
+}
+
+// Is makes the error match ErrNXDomain or ErrNoData, depending on the kind of answer.
+func (e *NotFoundError) Is(target error) bool {
+	if e.NXDomain {
+		return target == ErrNXDomain
+	}
+	return target == ErrNoData
+}
+
+// RcodeError is returned when a server answers a query with an error response code other
+// than NXDOMAIN, e.g. SERVFAIL or REFUSED.
+type RcodeError struct {
+	// Rcode is the response code, one of the dns.Rcode* constants
+	Rcode int
+}
+
+func (e *RcodeError) Error() string {
+	return "dns error: " + dns.RcodeToString[e.Rcode]
+}
+
+// Is makes a SERVFAIL response match ErrServFail.
+func (e *RcodeError) Is(target error) bool {
+	return target == ErrServFail && e.Rcode == dns.RcodeServerFailure
+}
+
+// ResolverError is the failure of a single resolver, as collected by the strategies.
+type ResolverError struct {
+	// Resolver is the Name of the resolver that failed
+	Resolver string
+
+	// Err is the error the resolver returned
+	Err error
+}
+
+func (e *ResolverError) Error() string {
+	return e.Resolver + ": " + e.Err.Error()
+}
+
+func (e *ResolverError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes the error match ErrTimeout if the resolver timed out. Every transport reports
+// timeouts a little differently (context deadlines, socket deadlines, HTTP client timeouts),
+// but they all implement net.Error.
+func (e *ResolverError) Is(target error) bool {
+	return target == ErrTimeout && isTimeout(e.Err)
+}
+
+// isTimeout reports whether err is a timeout.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// AggregateError is returned by the strategies when every resolver failed, and carries the
+// failure of each one. That tells apart a resolver being down from the domain not existing,
+// which a single error can't.
+//
+// errors.Is and errors.As look into every resolver's error, so errors.Is(err, ErrTimeout)
+// is true if any of the resolvers timed out.
+type AggregateError struct {
+	// Host and Type are the query that failed
+	Host string
+	Type RecordType
+
+	// Errors holds one error per resolver that was queried, in the order they failed
+	Errors []*ResolverError
+}
+
+func (e *AggregateError) Error() string {
+	var b strings.Builder
+	b.WriteString("all resolvers failed for ")
+	b.WriteString(e.Host)
+	b.WriteString(" (")
+	b.WriteString(e.Type.String())
+	b.WriteString(")")
+	for i, err := range e.Errors {
+		if i == 0 {
+			b.WriteString(": ")
+		} else {
+			b.WriteString("; ")
+		}
+		b.WriteString(err.Error())
+	}
+	return b.String()
+}
+
+func (e *AggregateError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
+// negativeAnswer reports whether err is a definitive negative answer for a host, one we
+// can cache and report as not found. If the strategy queried several resolvers, all of
+// them have to agree: one saying NXDOMAIN while another timed out doesn't prove anything.
+func negativeAnswer(err error) (*NotFoundError, bool) {
+	// Consensus reports resolvers agreeing on a negative answer as a NotFoundError itself.
+	// If consensus wasn't reached, whatever negative answers it carries are a minority.
+	if errors.Is(err, ErrConsensusNotReached) {
+		return nil, false
+	}
+
+	var agg *AggregateError
+	if errors.As(err, &agg) {
+		var combined *NotFoundError
+		for _, rerr := range agg.Errors {
+			notFound, ok := negativeAnswer(rerr.Err)
+			if !ok {
+				return nil, false
+			}
+			combined = mergeNotFound(combined, notFound)
+		}
+		return combined, combined != nil
+	}
+
+	var notFound *NotFoundError
+	if errors.As(err, &notFound) {
+		return notFound, true
+	}
+	return nil, false
+}
+
+// mergeNotFound combines two negative answers for the same host. The result is NXDOMAIN if
+// either of them is, since that applies to the name rather than a single type, and it may
+// only be cached as long as the shorter of the two negative TTLs.
+func mergeNotFound(a, b *NotFoundError) *NotFoundError {
+	if a == nil {
+		merged := *b
+		return &merged
+	}
+	return &NotFoundError{
+		Host:     a.Host,
+		NXDomain: a.NXDomain || b.NXDomain,
+		ttl:      min(a.ttl, b.ttl),
+	}
+}
+
+// newDNSError turns a lookup failure into a *net.DNSError, the error net.Dialer returns
+// for failed lookups. HTTP clients and other code written against the standard library
+// check for it (e.g. IsNotFound, IsTimeout), and errors.Is and errors.As still reach the
+// original error through UnwrapErr.
+func newDNSError(host string, err error) *net.DNSError {
+	dnsErr := &net.DNSError{
+		Err:       err.Error(),
+		Name:      host,
+		UnwrapErr: err,
+		IsTimeout: errors.Is(err, ErrTimeout),
+	}
+	dnsErr.IsTemporary = dnsErr.IsTimeout || errors.Is(err, ErrServFail)
+
+	if _, ok := negativeAnswer(err); ok {
+		// Same message as the standard library, so "no such host" reads the same as ever
+		dnsErr.Err = "no such host"
+		dnsErr.IsNotFound = true
+	}
+	return dnsErr
+}
@@ -0,0 +1,124 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseResponse_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		rcode  int
+		target error
+	}{
+		{name: "nxdomain", rcode: dns.RcodeNameError, target: ErrNXDomain},
+		{name: "nodata", rcode: dns.RcodeSuccess, target: ErrNoData},
+		{name: "servfail", rcode: dns.RcodeServerFailure, target: ErrServFail},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := new(dns.Msg)
+			resp.SetRcode(newQuery("example.com", TypeA), tt.rcode)
+
+			_, err := parseResponse(resp)
+			assert.ErrorIs(t, err, tt.target)
+		})
+	}
+
+	// Other response codes don't match any of the sentinels, but keep the code
+	resp := new(dns.Msg)
+	resp.SetRcode(newQuery("example.com", TypeA), dns.RcodeRefused)
+	_, err := parseResponse(resp)
+	var rcodeErr *RcodeError
+	require.ErrorAs(t, err, &rcodeErr)
+	assert.Equal(t, dns.RcodeRefused, rcodeErr.Rcode)
+	assert.NotErrorIs(t, err, ErrServFail)
+	assert.Equal(t, "dns error: REFUSED", err.Error())
+}
+
+func TestRace_AggregateError(t *testing.T) {
+	resolvers := []Resolver{
+		&mockResolver{name: "resolver1", err: context.DeadlineExceeded},
+		&mockResolver{name: "resolver2", err: &RcodeError{Rcode: dns.RcodeServerFailure}},
+	}
+
+	_, err := Race{}.ResolveType(context.Background(), "example.com", TypeA, resolvers, &mockLogger{})
+
+	var agg *AggregateError
+	require.ErrorAs(t, err, &agg)
+	assert.Len(t, agg.Errors, 2)
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.ErrorIs(t, err, ErrServFail)
+	assert.NotErrorIs(t, err, ErrNXDomain)
+}
+
+func TestConsensus_Errors(t *testing.T) {
+	nxdomain := &NotFoundError{Host: "example.com", NXDomain: true}
+
+	// Two out of three resolvers agreeing that the name doesn't exist is a consensus
+	resolvers := []Resolver{
+		&mockResolver{name: "resolver1", err: nxdomain},
+		&mockResolver{name: "resolver2", err: nxdomain},
+		&mockResolver{name: "resolver3", response: []Record{{Value: "1.1.1.1", TTL: 300}}},
+	}
+	_, err := Consensus{}.ResolveType(context.Background(), "example.com", TypeA, resolvers, &mockLogger{})
+	assert.ErrorIs(t, err, ErrNXDomain)
+	assert.NotErrorIs(t, err, ErrConsensusNotReached)
+
+	// A single one isn't, and doesn't make the result a negative answer either
+	resolvers = []Resolver{
+		&mockResolver{name: "resolver1", err: nxdomain},
+		&mockResolver{name: "resolver2", response: []Record{{Value: "1.1.1.1", TTL: 300}}},
+		&mockResolver{name: "resolver3", response: []Record{{Value: "2.2.2.2", TTL: 300}}},
+	}
+	_, err = Consensus{}.ResolveType(context.Background(), "example.com", TypeA, resolvers, &mockLogger{})
+	assert.ErrorIs(t, err, ErrConsensusNotReached)
+	_, negative := negativeAnswer(err)
+	assert.False(t, negative)
+}
+
+func TestNegativeAnswer_RequiresAllResolvers(t *testing.T) {
+	err := &AggregateError{Host: "example.com", Type: TypeA, Errors: []*ResolverError{
+		{Resolver: "resolver1", Err: &NotFoundError{Host: "example.com", NXDomain: true}},
+		{Resolver: "resolver2", Err: context.DeadlineExceeded},
+	}}
+	_, negative := negativeAnswer(err)
+	assert.False(t, negative)
+
+	err.Errors[1].Err = &NotFoundError{Host: "example.com"}
+	notFound, negative := negativeAnswer(err)
+	require.True(t, negative)
+	assert.True(t, notFound.NXDomain)
+}
+
+func TestDialer_DialContext_DNSError(t *testing.T) {
+	addr := startTestDNSServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetRcode(req, dns.RcodeNameError)
+		_ = w.WriteMsg(resp)
+	})
+
+	dialer := New(WithResolvers(addr))
+	defer dialer.Close()
+
+	_, err := dialer.DialContext(context.Background(), "tcp", "missing.example.com:443")
+
+	// Looks like a failed lookup from net.Dialer, while the details stay reachable
+	var dnsErr *net.DNSError
+	require.ErrorAs(t, err, &dnsErr)
+	assert.True(t, dnsErr.IsNotFound)
+	assert.Equal(t, "missing.example.com", dnsErr.Name)
+	assert.Equal(t, "dial tcp: lookup missing.example.com: no such host", err.Error())
+	assert.ErrorIs(t, err, ErrNXDomain)
+}
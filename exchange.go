@@ -0,0 +1,162 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// exchanger is implemented by the built-in resolvers, which can send any query message
+// rather than only a question for a single record type.
+type exchanger interface {
+	exchange(ctx context.Context, msg *dns.Msg) (*dns.Msg, error)
+}
+
+// Exchange sends msg through the configured strategy and returns the full response, along
+// with the Name of the resolver whose response it is.
+//
+// Unlike Query, which only returns the answer records, the response has everything the
+// server sent: header flags like AA, AD and RA, the authority and additional sections, and
+// EDNS options. The message is sent as is, so set the flags and options you need on it,
+// e.g. the DO bit for DNSSEC records. Its ID is kept in the response.
+//
+// Responses aren't cached, and only the built-in transports take part, custom resolvers
+// can't send arbitrary messages. A response with an NXDOMAIN response code is a successful
+// exchange, while other error codes, like SERVFAIL, count as the resolver failing, so
+// strategies move on to the other resolvers. Consensus and Compare compare the answer
+// sections.
+//
+// Example:
+//
+//	msg := new(dns.Msg)
+//	msg.SetQuestion("example.com.", dns.TypeSOA)
+//	msg.SetEdns0(4096, true)
+//	resp, resolver, err := dialer.Exchange(ctx, msg)
+func (r *Dialer) Exchange(ctx context.Context, msg *dns.Msg) (*dns.Msg, string, error) {
+	if err := r.checkUsable(); err != nil {
+		return nil, "", err
+	}
+	if len(msg.Question) != 1 {
+		return nil, "", errors.New("exchange requires a message with exactly one question")
+	}
+
+	run := &exchangeRun{msg: msg}
+	active, _ := r.activeResolvers()
+	resolvers := make([]Resolver, 0, len(active))
+	for _, res := range active {
+		if ex, ok := res.(exchanger); ok {
+			resolvers = append(resolvers, &exchangeResolver{run: run, name: res.Name(), ex: ex})
+		}
+	}
+	if len(resolvers) == 0 {
+		return nil, "", errors.New("no resolver supports exchanging raw messages")
+	}
+
+	q := msg.Question[0]
+	host := strings.TrimSuffix(q.Name, ".")
+	records, err := r.strategy.ResolveType(ctx, host, RecordType(q.Qtype), resolvers, r.logger)
+	if err != nil {
+		return nil, "", err
+	}
+
+	result, ok := run.find(records)
+	if !ok {
+		return nil, "", errors.New("strategy returned records that no resolver answered with")
+	}
+	return result.response, result.resolver, nil
+}
+
+// exchangeRun collects the responses of the resolvers taking part in one Exchange.
+type exchangeRun struct {
+	// msg is the caller's message, every resolver sends its own copy of it
+	msg *dns.Msg
+
+	// mu protects results, resolvers run concurrently with Race
+	mu      sync.Mutex
+	results []exchangeResult
+}
+
+// exchangeResult is the response of a single resolver, and the records it gave the strategy.
+type exchangeResult struct {
+	resolver string
+	response *dns.Msg
+	records  []Record
+}
+
+// find returns the response the records returned by the strategy came from.
+//
+// The built-in strategies return one of the resolvers' record slices as is, so we first
+// look for that exact slice. That tells apart resolvers with identical answers, e.g. the
+// winner of a Race. A custom strategy may build a new slice, then the first response with
+// equal records will do.
+func (run *exchangeRun) find(records []Record) (exchangeResult, bool) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+
+	for _, result := range run.results {
+		if sameSlice(result.records, records) {
+			return result, true
+		}
+	}
+	for _, result := range run.results {
+		if recordsEqual(result.records, records, false) {
+			return result, true
+		}
+	}
+	return exchangeResult{}, false
+}
+
+// sameSlice reports whether a and b share the same backing array. exchangeResolver always
+// allocates room for at least one record, so this holds for empty answers too.
+func sameSlice(a, b []Record) bool {
+	if cap(a) == 0 || cap(b) == 0 {
+		return false
+	}
+	return &a[:1][0] == &b[:1][0]
+}
+
+// exchangeResolver adapts a built-in resolver to the Resolver interface for Exchange: it
+// sends the run's message rather than a question built from host and type, and keeps the
+// full response around.
+type exchangeResolver struct {
+	run  *exchangeRun
+	name string
+	ex   exchanger
+}
+
+func (e *exchangeResolver) ResolveType(ctx context.Context, host string, qtype RecordType) ([]Record, error) {
+	// Transports set their own message ID on the message they're given, so each of them
+	// needs its own copy when they run concurrently
+	response, err := e.ex.exchange(ctx, e.run.msg.Copy())
+	if err != nil {
+		return nil, err
+	}
+	response.Id = e.run.msg.Id
+
+	if response.Rcode != dns.RcodeSuccess && response.Rcode != dns.RcodeNameError {
+		return nil, &RcodeError{Rcode: response.Rcode}
+	}
+
+	records := make([]Record, 0, len(response.Answer)+1)
+	if parsed, err := parseResponse(response); err == nil {
+		records = append(records, parsed...)
+	}
+
+	e.run.mu.Lock()
+	e.run.results = append(e.run.results, exchangeResult{resolver: e.name, response: response, records: records})
+	e.run.mu.Unlock()
+
+	return records, nil
+}
+
+func (e *exchangeResolver) Name() string {
+	return e.name
+}
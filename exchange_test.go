@@ -0,0 +1,100 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialer_Exchange(t *testing.T) {
+	addr := startTestDNSServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		resp.Authoritative = true
+		resp.Answer = append(resp.Answer, &dns.NS{
+			Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 60},
+			Ns:  "ns1.example.com.",
+		})
+		resp.Extra = append(resp.Extra, &dns.A{
+			Hdr: dns.RR_Header{Name: "ns1.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP("192.0.2.53"),
+		})
+		if opt := req.IsEdns0(); opt != nil {
+			resp.SetEdns0(opt.UDPSize(), opt.Do())
+		}
+		_ = w.WriteMsg(resp)
+	})
+
+	dialer := New(WithResolvers(addr))
+	defer dialer.Close()
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("example.com.", dns.TypeNS)
+	msg.SetEdns0(4096, true)
+
+	resp, resolver, err := dialer.Exchange(context.Background(), msg)
+	require.NoError(t, err)
+	assert.Equal(t, addr, resolver)
+	assert.Equal(t, msg.Id, resp.Id)
+	assert.True(t, resp.Authoritative)
+	assert.Len(t, resp.Answer, 1)
+
+	// The glue record and the EDNS OPT record both end up in the additional section
+	require.Len(t, resp.Extra, 2)
+	assert.Equal(t, "ns1.example.com.", resp.Extra[0].Header().Name)
+	require.NotNil(t, resp.IsEdns0())
+	assert.True(t, resp.IsEdns0().Do())
+}
+
+func TestDialer_Exchange_Race(t *testing.T) {
+	servfail := startTestDNSServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetRcode(req, dns.RcodeServerFailure)
+		_ = w.WriteMsg(resp)
+	})
+	nxdomain := startTestDNSServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		// Answer a little later, so the SERVFAIL comes first
+		time.Sleep(20 * time.Millisecond)
+		resp := new(dns.Msg)
+		resp.SetRcode(req, dns.RcodeNameError)
+		_ = w.WriteMsg(resp)
+	})
+
+	dialer := New(
+		WithResolvers(servfail, nxdomain),
+		WithStrategy(Race{}),
+	)
+	defer dialer.Close()
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("missing.example.com.", dns.TypeA)
+
+	// SERVFAIL counts as a failure, NXDOMAIN is a perfectly fine response
+	resp, resolver, err := dialer.Exchange(context.Background(), msg)
+	require.NoError(t, err)
+	assert.Equal(t, nxdomain, resolver)
+	assert.Equal(t, dns.RcodeNameError, resp.Rcode)
+}
+
+func TestDialer_Exchange_Errors(t *testing.T) {
+	dialer := New(WithCustomResolvers(&mockResolver{name: "custom"}))
+	defer dialer.Close()
+
+	msg := new(dns.Msg)
+	_, _, err := dialer.Exchange(context.Background(), msg)
+	assert.Error(t, err)
+
+	msg.SetQuestion("example.com.", dns.TypeA)
+	_, _, err = dialer.Exchange(context.Background(), msg)
+	assert.EqualError(t, err, "no resolver supports exchanging raw messages")
+}
@@ -7,10 +7,12 @@ package dnsdialer
 
 import (
 	"context"
+	"errors"
+	"math/rand/v2"
 )
 
-func (s Fallback) ResolveType(ctx context.Context, host string, qtype RecordType, resolvers []resolver, logger Logger) ([]Record, error) {
-	var lastErr error
+func (s Fallback) ResolveType(ctx context.Context, host string, qtype RecordType, resolvers []Resolver, logger Logger) ([]Record, error) {
+	var errs []*ResolverError
 
 	// Try each resolver in order until one succeeds. This provides ordered failover,
 	// useful when you have a preferred resolver (e.g., internal DNS) but want to fall
@@ -18,26 +20,57 @@ func (s Fallback) ResolveType(ctx context.Context, host string, qtype RecordType
 	//
 	// Unlike Race, this minimizes network traffic by only querying one resolver at a time.
 	// The trade-off is higher latency if early resolvers in the list are slow or down.
-	for _, res := range resolvers {
-		records, err := res.ResolveType(ctx, host, qtype)
-		if err == nil {
-			logger.Debug("resolver succeeded",
+	start := 0
+	if s.Rotate && len(resolvers) > 0 {
+		start = rand.IntN(len(resolvers))
+	}
+
+	for attempt := 0; attempt < max(s.Attempts, 1); attempt++ {
+		for i := range resolvers {
+			res := resolvers[(start+i)%len(resolvers)]
+			records, err := res.ResolveType(ctx, host, qtype)
+			if err == nil {
+				logger.Debug("resolver succeeded",
+					Field{"resolver", res.Name()},
+					Field{"type", qtype.String()})
+				return records, nil
+			}
+			// Keep trying the remaining resolvers in this pass, even after a negative answer,
+			// since another resolver may still know the host. Whether the errors are worth
+			// another pass is decided once all of them have been asked.
+			errs = append(errs, &ResolverError{Resolver: res.Name(), Err: err})
+			logger.Debug("resolver failed, trying next",
 				Field{"resolver", res.Name()},
-				Field{"type", qtype.String()})
-			return records, nil
+				Field{"type", qtype.String()},
+				Field{"attempt", attempt + 1},
+				Field{"error", err.Error()})
+		}
+
+		// Going through the list again only helps with failures like timeouts, it won't
+		// change the answer of resolvers that said the host doesn't exist
+		if !retryable(errs) {
+			break
 		}
-		// Keep trying the remaining resolvers. The error might be temporary like a timeout
-		// or network issue, or permanent like domain doesn't exist. We can't really distinguish,
-		// so we just try all resolvers before giving up.
-		lastErr = err
-		logger.Debug("resolver failed, trying next",
-			Field{"resolver", res.Name()},
-			Field{"type", qtype.String()},
-			Field{"error", err.Error()})
 	}
 
-	// All resolvers failed. Return the last error, which may not be the most informative
-	// one, but it's from the last fallback option we tried. Consider logging all errors
-	// if you're debugging why all resolvers failed.
-	return nil, lastErr
+	// All resolvers failed. Return every resolver's error rather than just the last one,
+	// which may not be the most informative, e.g. a timeout from the last fallback option
+	// hiding an NXDOMAIN from the first.
+	return nil, &AggregateError{Host: host, Type: qtype, Errors: errs}
+}
+
+// retryable reports whether any of the resolvers failed in a way that trying again might fix,
+// rather than answering that the host doesn't exist or rejecting the query for good.
+func retryable(errs []*ResolverError) bool {
+	for _, err := range errs {
+		if _, negative := negativeAnswer(err.Err); negative {
+			continue
+		}
+		var statusErr *HTTPStatusError
+		if errors.As(err.Err, &statusErr) && statusErr.permanent() {
+			continue
+		}
+		return true
+	}
+	return false
 }
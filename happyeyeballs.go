@@ -0,0 +1,329 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// defaultAttemptDelay is the Connection Attempt Delay recommended by RFC 8305
+const defaultAttemptDelay = 250 * time.Millisecond
+
+// resolutionDelay is how long a dial waits for the preferred address family to be answered
+// once the other one is, before dialing those addresses alone (RFC 8305 section 3)
+const resolutionDelay = 50 * time.Millisecond
+
+// errSlowAttempt is what a connection attempt that was still hanging when another address
+// won the race is quarantined with
+var errSlowAttempt = errors.New("no connection within the attempt delay")
+
+// interleaveFamilies orders ips so IPv4 and IPv6 addresses alternate, starting with the
+// family of the first address, the preferred one, and keeping the order within each family
+// (RFC 8305 section 4).
+func interleaveFamilies(ips []net.IP) []net.IP {
+	var preferred, other []net.IP
+	for _, ip := range ips {
+		if (ip.To4() != nil) == (ips[0].To4() != nil) {
+			preferred = append(preferred, ip)
+		} else {
+			other = append(other, ip)
+		}
+	}
+
+	interleaved := make([]net.IP, 0, len(ips))
+	for i := 0; i < max(len(preferred), len(other)); i++ {
+		if i < len(preferred) {
+			interleaved = append(interleaved, preferred[i])
+		}
+		if i < len(other) {
+			interleaved = append(interleaved, other[i])
+		}
+	}
+	return interleaved
+}
+
+// lookupAnswer is an answer of a lookup dialResolving runs in the background.
+type lookupAnswer struct {
+	ips []net.IP
+	err error
+
+	// final is set on the complete answer, otherwise ips are those of the first family
+	// answered while the other is still outstanding
+	final bool
+}
+
+// pendingAnswer is the rest of the answer for a host whose first answered family is already
+// being dialed.
+type pendingAnswer struct {
+	// answers delivers the complete answer
+	answers <-chan lookupAnswer
+
+	// known are the addresses dialing started with, they're left out of the rest
+	known []net.IP
+
+	// quarantined collects the quarantined addresses of the rest, dialAddrs dials them last
+	quarantined []net.IP
+}
+
+// rest returns the addresses of the complete answer ans that weren't known yet and aren't
+// quarantined. The quarantined ones are set aside in p.
+func (r *Dialer) rest(p *pendingAnswer, ans lookupAnswer) []net.IP {
+	var added []net.IP
+	for _, ip := range ans.ips {
+		if !slices.ContainsFunc(p.known, ip.Equal) {
+			added = append(added, ip)
+		}
+	}
+	healthy, quarantined := r.quarantine.partition(added)
+	p.quarantined = append(p.quarantined, quarantined...)
+	return healthy
+}
+
+// dialResolving resolves host and connects to it like DialContext, but doesn't wait for
+// both address families to be answered (RFC 8305 section 3). Dialing starts as soon as the
+// preferred family is answered, IPv6 unless the address preference is PreferIPv4, and the
+// other family's addresses join the race once they come in. If the other family is answered
+// first, it gets resolutionDelay for the preferred one to follow, as with it answered too
+// the attempts can take turns between both.
+func (r *Dialer) dialResolving(ctx context.Context, network, host, port string, qtypes []RecordType) (net.Conn, error) {
+	// If the dial is done before the lookup, the lookup keeps going, so the complete answer
+	// still makes it into the cache. It's only canceled if the dial fails.
+	lctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	answers := make(chan lookupAnswer, 2)
+	go func() {
+		defer cancel()
+		ips, err := r.lookupIPTypesEarly(lctx, host, qtypes, func(ips []net.IP) {
+			answers <- lookupAnswer{ips: ips}
+		})
+		answers <- lookupAnswer{ips: ips, err: err, final: true}
+	}()
+
+	lookupFailed := func(err error) error {
+		cancel()
+		// Report lookup failures the way net.Dialer does, see DialContext
+		return &net.OpError{Op: "dial", Net: network, Err: newDNSError(host, err)}
+	}
+
+	var ans lookupAnswer
+	select {
+	case ans = <-answers:
+	case <-ctx.Done():
+		return nil, lookupFailed(ctx.Err())
+	}
+
+	preferV4 := r.addrPreference == PreferIPv4
+	if !ans.final && (ans.ips[0].To4() != nil) != preferV4 {
+		timer := time.NewTimer(resolutionDelay)
+		select {
+		case ans = <-answers:
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, lookupFailed(ctx.Err())
+		}
+		timer.Stop()
+	}
+
+	if ans.final {
+		if ans.err != nil {
+			return nil, lookupFailed(ans.err)
+		}
+		return r.dialAddrs(ctx, network, host, port, r.dialOrder(network, ans.ips), nil)
+	}
+
+	r.logger.Debug("dialing first answered address family",
+		Field{"host", host},
+		Field{"ips", len(ans.ips)})
+	conn, err := r.dialAddrs(ctx, network, host, port, r.dialOrder(network, ans.ips), &pendingAnswer{answers: answers, known: ans.ips})
+	if err != nil {
+		cancel()
+	}
+	return conn, err
+}
+
+// dialResult is the outcome of a single connection attempt.
+type dialResult struct {
+	conn net.Conn
+	err  error
+	ip   net.IP
+
+	// attempt is the index of the attempt in the race
+	attempt int
+}
+
+// dialIPs connects to one of ips, racing the attempts the way Happy Eyeballs does (RFC 8305).
+//
+// Trying the addresses one after the other means a single blackholed address stalls the
+// dial for the full connect timeout. Instead, each attempt gets attemptDelay to connect
+// before the next address is tried in parallel, and an attempt that fails right away, e.g.
+// with a connection refused, starts the next one without waiting. The first connection
+// wins, the attempts still running are canceled, and any that connect anyway are closed.
+// If pending is set, dialing started on the first address family answered, and the
+// addresses of the rest of the answer are added to the race once they come in. Until then,
+// the race isn't over even if every attempt so far failed.
+//
+// Connectionless networks have nothing to race, dialing them never waits on the network,
+// so they simply go through the addresses in order.
+func (r *Dialer) dialIPs(ctx context.Context, network, host, port string, ips []net.IP, pending *pendingAnswer) (net.Conn, error) {
+	if !strings.HasPrefix(network, "tcp") {
+		var lastErr error
+		for _, ip := range ips {
+			conn, err := r.dial(ctx, network, net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				r.quarantine.succeeded(ip)
+				return conn, nil
+			}
+			if ctx.Err() == nil {
+				r.quarantine.failed(ip, err)
+			}
+			lastErr = err
+		}
+		return nil, fmt.Errorf("failed to connect to %s: %w", host, lastErr)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Buffered for every attempt, so the ones still running when we return don't block
+	results := make(chan dialResult, len(ips))
+	next, running := 0, 0
+
+	// started holds when each attempt started, until its result comes in. slow marks the
+	// attempts that used up their full head start without connecting.
+	started := make(map[int]time.Time, len(ips))
+	slow := make(map[int]bool, len(ips))
+	start := func() {
+		attempt, ip := next, ips[next]
+		next++
+		running++
+		started[attempt] = time.Now()
+		go func() {
+			conn, err := r.dial(ctx, network, net.JoinHostPort(ip.String(), port))
+			results <- dialResult{conn: conn, err: err, ip: ip, attempt: attempt}
+		}()
+	}
+
+	// more delivers the rest of the answer while it's outstanding
+	var more <-chan lookupAnswer
+	if pending != nil {
+		more = pending.answers
+	}
+
+	// Without a delay, the next attempt only starts once the previous one failed. stalled is
+	// set when it's time for the next attempt, but there was no address left to try.
+	stalled := false
+	var timer *time.Timer
+	var timeout <-chan time.Time
+	if r.attemptDelay >= 0 {
+		timer = time.NewTimer(r.attemptDelay)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	startNext := func() {
+		if next < len(ips) && ctx.Err() == nil {
+			start()
+			stalled = false
+			if timer != nil {
+				timer.Reset(r.attemptDelay)
+			}
+		} else {
+			stalled = true
+		}
+	}
+
+	startNext()
+	var lastErr error
+	for running > 0 || more != nil {
+		// With no attempt running, nothing else notices the dial being given up on
+		var canceled <-chan struct{}
+		if running == 0 {
+			canceled = ctx.Done()
+		}
+
+		select {
+		case res := <-results:
+			running--
+			delete(started, res.attempt)
+			if res.err == nil {
+				cancel()
+				go closeLosers(results, running)
+				r.quarantine.succeeded(res.ip)
+
+				// A blackholed address never fails on its own, it just hangs until it's
+				// canceled. Attempts that already had their full head start and still hadn't
+				// connected are as good as failed, or every dial would wait for them again.
+				for attempt, at := range started {
+					if slow[attempt] {
+						r.quarantine.failed(ips[attempt], fmt.Errorf("%w: still connecting after %s when %s connected",
+							errSlowAttempt, time.Since(at).Round(time.Millisecond), res.ip))
+					}
+				}
+				return res.conn, nil
+			}
+
+			// An attempt that failed because the dial as a whole was canceled, or timed out,
+			// says nothing about the address
+			if ctx.Err() == nil {
+				r.quarantine.failed(res.ip, res.err)
+			}
+			lastErr = res.err
+			r.logger.Debug("connection failed, trying next IP",
+				Field{"ip", res.ip.String()},
+				Field{"error", res.err.Error()})
+			startNext()
+
+		case <-timeout:
+			// The timer runs from the latest attempt, which had its head start without
+			// connecting. Without a delay there's no head start, and all attempts start at
+			// once, so none of them is slow.
+			if _, running := started[next-1]; running && r.attemptDelay > 0 {
+				slow[next-1] = true
+			}
+			r.logger.Debug("connection attempt is slow, trying next IP in parallel",
+				Field{"host", host},
+				Field{"delay", r.attemptDelay.String()})
+			startNext()
+
+		case ans := <-more:
+			more = nil
+			added := r.rest(pending, ans)
+			if len(added) == 0 {
+				continue
+			}
+			r.logger.Debug("adding addresses of the other family to the race",
+				Field{"host", host},
+				Field{"ips", len(added)})
+
+			// Sort the addresses that haven't been tried yet again, along with the new ones
+			remaining := append(slices.Clone(ips[next:]), added...)
+			ips = append(ips[:next:next], r.dialOrder(network, remaining)...)
+			if stalled {
+				startNext()
+			}
+
+		case <-canceled:
+			return nil, fmt.Errorf("failed to connect to %s: %w", host, ctx.Err())
+		}
+	}
+
+	return nil, fmt.Errorf("failed to connect to %s: %w", host, lastErr)
+}
+
+// closeLosers waits for the remaining attempts of a race that's already been won, and
+// closes the connections of the ones that got through before they were canceled.
+func closeLosers(results <-chan dialResult, remaining int) {
+	for ; remaining > 0; remaining-- {
+		if res := <-results; res.conn != nil {
+			res.conn.Close()
+		}
+	}
+}
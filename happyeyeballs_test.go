@@ -0,0 +1,216 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterleaveFamilies(t *testing.T) {
+	ips := []net.IP{
+		net.ParseIP("2001:db8::1"),
+		net.ParseIP("192.0.2.1"),
+		net.ParseIP("192.0.2.2"),
+		net.ParseIP("192.0.2.3"),
+		net.ParseIP("2001:db8::2"),
+	}
+
+	// The first address is the most preferred, its family goes first
+	assert.Equal(t, []net.IP{
+		net.ParseIP("2001:db8::1"),
+		net.ParseIP("192.0.2.1"),
+		net.ParseIP("2001:db8::2"),
+		net.ParseIP("192.0.2.2"),
+		net.ParseIP("192.0.2.3"),
+	}, interleaveFamilies(ips))
+}
+
+// blackholeDialer dials addresses on the loopback interface, and hangs on any other address
+// until the attempt is canceled, like a dial to a blackholed address does.
+type blackholeDialer struct {
+	mu       sync.Mutex
+	canceled []string
+}
+
+func (b *blackholeDialer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, _ := net.SplitHostPort(addr)
+	if net.ParseIP(host).IsLoopback() {
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	}
+
+	<-ctx.Done()
+	b.mu.Lock()
+	b.canceled = append(b.canceled, host)
+	b.mu.Unlock()
+	return nil, ctx.Err()
+}
+
+func TestDialer_DialContext_HappyEyeballs(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	dialer := New(
+		WithCustomResolvers(&mockResolver{name: "dns", err: errors.New("must not be queried")}),
+		WithStaticHosts(map[string][]netip.Addr{
+			"api.example.com": {netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("127.0.0.1")},
+		}),
+		WithConnectionAttemptDelay(20*time.Millisecond),
+		WithAddressPreference(PreferIPv4),
+	)
+	defer dialer.Close()
+	blackhole := &blackholeDialer{}
+	dialer.dial = blackhole.dial
+
+	// The blackholed address gets its head start, then loses to the next one
+	start := time.Now()
+	conn, err := dialer.DialContext(context.Background(), "tcp", net.JoinHostPort("api.example.com", port))
+	require.NoError(t, err)
+	conn.Close()
+	assert.Less(t, time.Since(start), time.Second)
+
+	assert.Eventually(t, func() bool {
+		blackhole.mu.Lock()
+		defer blackhole.mu.Unlock()
+		return len(blackhole.canceled) == 1
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestDialer_DialContext_AttemptFailsFast(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	dialer := New(
+		WithCustomResolvers(&mockResolver{name: "dns", err: errors.New("must not be queried")}),
+		WithStaticHosts(map[string][]netip.Addr{
+			"api.example.com": {netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("127.0.0.1")},
+		}),
+		WithConnectionAttemptDelay(-1),
+		WithAddressPreference(PreferIPv4),
+	)
+	defer dialer.Close()
+
+	var attempts []string
+	dialer.dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		attempts = append(attempts, addr)
+		if host, _, _ := net.SplitHostPort(addr); host == "192.0.2.1" {
+			return nil, errors.New("connection refused")
+		}
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	}
+
+	// Without racing, the next address is only tried once the first one failed
+	conn, err := dialer.DialContext(context.Background(), "tcp", net.JoinHostPort("api.example.com", port))
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, []string{net.JoinHostPort("192.0.2.1", port), net.JoinHostPort("127.0.0.1", port)}, attempts)
+
+	dialer.dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return nil, errors.New("connection refused")
+	}
+	_, err = dialer.DialContext(context.Background(), "tcp", net.JoinHostPort("api.example.com", port))
+	assert.EqualError(t, err, "failed to connect to api.example.com: connection refused")
+}
+
+// familyResolver answers A and AAAA queries with the given addresses, each after its own
+// delay. A negative delay never answers, like a query that is blackholed.
+type familyResolver struct {
+	a, aaaa           string
+	aDelay, aaaaDelay time.Duration
+}
+
+func (f *familyResolver) ResolveType(ctx context.Context, host string, qtype RecordType) ([]Record, error) {
+	value, delay := f.a, f.aDelay
+	if qtype == TypeAAAA {
+		value, delay = f.aaaa, f.aaaaDelay
+	}
+
+	var wait <-chan time.Time
+	if delay >= 0 {
+		wait = time.After(delay)
+	}
+	select {
+	case <-wait:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if value == "" {
+		return nil, &NotFoundError{Host: host}
+	}
+	return []Record{{Type: qtype, Value: value, TTL: 60}}, nil
+}
+
+func (f *familyResolver) Name() string {
+	return "family"
+}
+
+func TestDialer_DialContext_SlowAAAA(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	dialer := New(WithCustomResolvers(&familyResolver{a: "127.0.0.1", aaaaDelay: -1}))
+	defer dialer.Close()
+
+	// The AAAA query never completes, the A answer is dialed once the resolution delay is up
+	start := time.Now()
+	conn, err := dialer.DialContext(context.Background(), "tcp", net.JoinHostPort("api.example.com", port))
+	require.NoError(t, err)
+	conn.Close()
+	assert.GreaterOrEqual(t, time.Since(start), resolutionDelay)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestDialer_DialContext_LateFamilyJoinsRace(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	dialer := New(WithCustomResolvers(&familyResolver{
+		a:         "127.0.0.1",
+		aDelay:    100 * time.Millisecond,
+		aaaa:      "2001:db8::1",
+		aaaaDelay: 0,
+	}))
+	defer dialer.Close()
+
+	var mu sync.Mutex
+	var attempts []string
+	dialer.dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, _ := net.SplitHostPort(addr)
+		mu.Lock()
+		attempts = append(attempts, host)
+		mu.Unlock()
+		if host == "2001:db8::1" {
+			return nil, errors.New("connection refused")
+		}
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	}
+
+	// The IPv6 address is dialed right away and fails, the dial then waits for the A answer
+	conn, err := dialer.DialContext(context.Background(), "tcp", net.JoinHostPort("api.example.com", port))
+	require.NoError(t, err)
+	conn.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"2001:db8::1", "127.0.0.1"}, attempts)
+}
@@ -0,0 +1,148 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultHostsPath is where the hosts file lives on Unix systems
+	defaultHostsPath = "/etc/hosts"
+
+	// hostsRecheck is how often the hosts file is checked for changes, the same interval
+	// the net package uses
+	hostsRecheck = 5 * time.Second
+)
+
+// Sources a lookup can be answered from, used in log messages.
+const (
+	sourceStatic    = "static"
+	sourceHostsFile = "hosts"
+)
+
+// hostsKey normalizes a host name for looking it up in static hosts or the hosts file.
+// Names are case-insensitive, and a trailing dot doesn't make a difference there.
+func hostsKey(host string) string {
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// hostsFile is a hosts file, like /etc/hosts, that's read lazily and reread when it changes.
+type hostsFile struct {
+	path string
+
+	// recheck is how often the file is checked for changes
+	recheck time.Duration
+
+	// mu protects the fields below, lookups run concurrently
+	mu      sync.Mutex
+	byName  map[string][]netip.Addr
+	checked time.Time
+	modTime time.Time
+	size    int64
+}
+
+func newHostsFile(path string) *hostsFile {
+	return &hostsFile{path: path, recheck: hostsRecheck}
+}
+
+// lookup returns the addresses listed for host in the file.
+//
+// The file is checked for changes at most once per recheck interval, by looking at its
+// modification time and size, and only read again if either changed.
+func (h *hostsFile) lookup(host string) []netip.Addr {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if now := time.Now(); h.checked.IsZero() || now.Sub(h.checked) >= h.recheck {
+		h.checked = now
+		h.reload()
+	}
+	return h.byName[hostsKey(host)]
+}
+
+// reload reads the file again if it changed since it was last read. A file that doesn't
+// exist, or can't be read, has no entries.
+func (h *hostsFile) reload() {
+	info, err := os.Stat(h.path)
+	if err != nil {
+		h.byName, h.modTime, h.size = nil, time.Time{}, 0
+		return
+	}
+	if h.byName != nil && info.ModTime().Equal(h.modTime) && info.Size() == h.size {
+		return
+	}
+
+	data, err := os.ReadFile(h.path)
+	if err != nil {
+		h.byName, h.modTime, h.size = nil, time.Time{}, 0
+		return
+	}
+	h.byName = parseHosts(data)
+	h.modTime, h.size = info.ModTime(), info.Size()
+}
+
+// parseHosts parses the content of a hosts file, see hosts(5). Each line has an address
+// followed by the names it belongs to, and a name listed on several lines gets the
+// addresses of all of them, in order.
+func parseHosts(data []byte) map[string][]netip.Addr {
+	byName := make(map[string][]netip.Addr)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		addr, err := netip.ParseAddr(fields[0])
+		if err != nil {
+			continue
+		}
+		addr = addr.Unmap()
+
+		for _, name := range fields[1:] {
+			key := hostsKey(name)
+			byName[key] = append(byName[key], addr)
+		}
+	}
+	return byName
+}
+
+// lookupLocal answers a lookup from the static hosts or the hosts file, before any query
+// goes out, and reports which of them answered.
+//
+// Static hosts take precedence over the hosts file, so they can override names pinned
+// there, e.g. in staging. A name found in either is never looked up through DNS, even if
+// it only has addresses of one family there.
+func (r *Dialer) lookupLocal(host string) ([]net.IP, string) {
+	if addrs, ok := r.staticHosts[hostsKey(host)]; ok {
+		return addrsToIPs(addrs), sourceStatic
+	}
+	if r.hostsFile != nil {
+		if addrs := r.hostsFile.lookup(host); len(addrs) > 0 {
+			return addrsToIPs(addrs), sourceHostsFile
+		}
+	}
+	return nil, ""
+}
+
+// addrsToIPs converts addresses to net.IP, which is what lookups deal in.
+func addrsToIPs(addrs []netip.Addr) []net.IP {
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = net.IP(addr.AsSlice())
+	}
+	return ips
+}
@@ -0,0 +1,105 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHosts(t *testing.T) {
+	hosts := parseHosts([]byte(`
+127.0.0.1   localhost
+::1         localhost ip6-localhost # loopback
+10.0.0.1    db.internal DB
+not-an-ip   ignored.internal
+# 10.0.0.9  commented.internal
+`))
+
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("127.0.0.1"), netip.MustParseAddr("::1")}, hosts["localhost"])
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, hosts["db"])
+	assert.NotContains(t, hosts, "ignored.internal")
+	assert.NotContains(t, hosts, "commented.internal")
+}
+
+func TestDialer_LocalHosts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	require.NoError(t, os.WriteFile(path, []byte("10.0.0.1 db.internal\n10.0.0.2 api.internal\n"), 0o644))
+
+	res := &mockResolver{name: "dns", response: []Record{{Type: TypeA, Value: "192.0.2.1", TTL: 60}}}
+	dialer := New(
+		WithCustomResolvers(res),
+		WithHostsFile(path),
+		WithStaticHosts(map[string][]netip.Addr{
+			"API.internal.": {netip.MustParseAddr("10.1.0.2")},
+		}),
+	)
+	defer dialer.Close()
+	ctx := context.Background()
+
+	// Static hosts come before the hosts file
+	ips, err := dialer.lookupIPs(ctx, "api.internal")
+	require.NoError(t, err)
+	assert.Equal(t, []net.IP{net.ParseIP("10.1.0.2").To4()}, ips)
+
+	ips, err = dialer.lookupIPs(ctx, "DB.internal")
+	require.NoError(t, err)
+	assert.Equal(t, []net.IP{net.ParseIP("10.0.0.1").To4()}, ips)
+
+	// Anything else goes to the resolvers
+	ips, err = dialer.lookupIPs(ctx, "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []net.IP{net.ParseIP("192.0.2.1")}, ips)
+}
+
+func TestHostsFile_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	hosts := newHostsFile(path)
+	hosts.recheck = 0
+
+	// A missing file has no entries
+	assert.Nil(t, hosts.lookup("db.internal"))
+
+	require.NoError(t, os.WriteFile(path, []byte("10.0.0.1 db.internal\n"), 0o644))
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, hosts.lookup("db.internal"))
+
+	require.NoError(t, os.WriteFile(path, []byte("10.0.0.22 db.internal\n"), 0o644))
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.22")}, hosts.lookup("db.internal"))
+
+	// Within the recheck interval, the file isn't looked at
+	hosts.recheck = time.Hour
+	require.NoError(t, os.Remove(path))
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.22")}, hosts.lookup("db.internal"))
+}
+
+func TestDialer_DialContext_StaticHosts(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	logger := &mockLogger{}
+	dialer := New(
+		WithCustomResolvers(&mockResolver{name: "dns", err: errors.New("must not be queried")}),
+		WithStaticHosts(map[string][]netip.Addr{"payments.internal": {netip.MustParseAddr("127.0.0.1")}}),
+		WithLogger(logger),
+	)
+	defer dialer.Close()
+
+	conn, err := dialer.DialContext(context.Background(), "tcp", net.JoinHostPort("payments.internal", port))
+	require.NoError(t, err)
+	conn.Close()
+	assert.Contains(t, logger.logs, "DEBUG: answered locally")
+}
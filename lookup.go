@@ -0,0 +1,368 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// The Lookup methods mirror the signatures of net.Resolver, so code written against the
+// standard library can switch to a Dialer to get its strategies, caching and transports
+// outside of dialing as well. Like net.Resolver, they report failures as *net.DNSError.
+//
+// Address lookups share the cache and in-flight lookups of DialContext. The other record
+// types are cached per host and type, with the same TTL bounds.
+
+// LookupHost looks up the given host and returns a slice of its addresses.
+func (r *Dialer) LookupHost(ctx context.Context, host string) ([]string, error) {
+	ips, err := r.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = ip.String()
+	}
+	return addrs, nil
+}
+
+// LookupIP looks up host for the given network and returns a slice of its IP addresses.
+// The network must be one of "ip", "ip4" or "ip6".
+func (r *Dialer) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	if network != "ip" && network != "ip4" && network != "ip6" {
+		return nil, net.UnknownNetworkError(network)
+	}
+
+	// No point in doing DNS resolution for something that's already an IP
+	if ip := net.ParseIP(host); ip != nil {
+		return filterIPFamily(network, host, []net.IP{ip})
+	}
+
+	if err := r.checkUsable(); err != nil {
+		return nil, err
+	}
+
+	// Like DialContext, skip the query for the family that wasn't asked for
+	qtypes := r.queryTypesFor(network)
+	if len(qtypes) == 0 {
+		return filterIPFamily(network, host, nil)
+	}
+
+	ips, err := r.lookupIPTypes(ctx, host, qtypes)
+	if err != nil {
+		return nil, newDNSError(host, err)
+	}
+	return filterIPFamily(network, host, ips)
+}
+
+// LookupIPAddr looks up host and returns a slice of its IPv4 and IPv6 addresses.
+func (r *Dialer) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ips, err := r.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]net.IPAddr, len(ips))
+	for i, ip := range ips {
+		addrs[i] = net.IPAddr{IP: ip}
+	}
+	return addrs, nil
+}
+
+// LookupNetIP looks up host and returns a slice of its IP addresses of the type specified
+// by network. The network must be one of "ip", "ip4" or "ip6".
+func (r *Dialer) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	ips, err := r.LookupIP(ctx, network, host)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]netip.Addr, 0, len(ips))
+	for _, ip := range ips {
+		if addr, ok := netip.AddrFromSlice(ip); ok {
+			// net.IP keeps IPv4 addresses in their 16-byte form, netip.Addr tells them apart
+			addrs = append(addrs, addr.Unmap())
+		}
+	}
+	return addrs, nil
+}
+
+// LookupCNAME returns the canonical name for the given host.
+//
+// Like net.Resolver, the canonical name is where the host's CNAME chain ends up, taken from
+// the addresses it resolves to, following every CNAME along the way. A host without a CNAME
+// record that resolves to addresses is its own canonical name. Only if the host has no
+// addresses at all do we fall back to asking for its CNAME record.
+//
+// The host goes through the static hosts, the hosts file and the search list like LookupIP,
+// and the addresses are looked up with the configured query types. A name found in the
+// static hosts or the hosts file is its own canonical name.
+func (r *Dialer) LookupCNAME(ctx context.Context, host string) (string, error) {
+	if err := r.checkUsable(); err != nil {
+		return "", err
+	}
+
+	if ips, _ := r.lookupLocal(host); ips != nil {
+		return dns.Fqdn(host), nil
+	}
+
+	if len(r.searchDomains) == 0 {
+		cname, err := r.canonicalName(ctx, host)
+		if err != nil {
+			return "", newDNSError(host, err)
+		}
+		return cname, nil
+	}
+
+	// Same as for addresses, only move on to the next name if this one doesn't exist
+	var firstErr error
+	for _, name := range r.searchNames(host) {
+		cname, err := r.canonicalName(ctx, name)
+		if err == nil {
+			return cname, nil
+		}
+		if _, negative := negativeAnswer(err); !negative {
+			return "", newDNSError(host, err)
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return "", newDNSError(host, firstErr)
+}
+
+// canonicalName returns the canonical name of a single name, see LookupCNAME.
+func (r *Dialer) canonicalName(ctx context.Context, name string) (string, error) {
+	// The chased records are owned by the name at the end of the chain. Custom resolvers
+	// may not set the owner, without a chain to follow the name itself is where it ends.
+	for _, qtype := range r.queryTypes {
+		if records, err := r.lookupRecords(ctx, name, qtype); err == nil {
+			if records[0].Name == "" {
+				return dns.Fqdn(name), nil
+			}
+			return dns.Fqdn(records[0].Name), nil
+		}
+	}
+
+	records, err := r.lookupRecords(ctx, name, TypeCNAME)
+	if err != nil {
+		return "", err
+	}
+	if cname, ok := records[0].data().(CNAMEData); ok {
+		return dns.Fqdn(cname.Target), nil
+	}
+	return "", &NotFoundError{Host: name}
+}
+
+// LookupMX returns the DNS MX records for the given domain name sorted by preference.
+func (r *Dialer) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	records, err := r.lookupTyped(ctx, name, TypeMX)
+	if err != nil {
+		return nil, err
+	}
+
+	mxs := make([]*net.MX, 0, len(records))
+	for _, record := range records {
+		if mx, ok := record.data().(MXData); ok {
+			mxs = append(mxs, &net.MX{Host: mx.Host, Pref: mx.Preference})
+		}
+	}
+
+	sort.SliceStable(mxs, func(i, j int) bool {
+		return mxs[i].Pref < mxs[j].Pref
+	})
+	return mxs, nil
+}
+
+// LookupTXT returns the DNS TXT records for the given domain name.
+func (r *Dialer) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	records, err := r.lookupTyped(ctx, name, TypeTXT)
+	if err != nil {
+		return nil, err
+	}
+
+	// Like net.Resolver, the strings of a record split into several of them are joined
+	// back together, they're only split to fit the 255 byte limit per string
+	txts := make([]string, 0, len(records))
+	for _, record := range records {
+		if txt, ok := record.data().(TXTData); ok {
+			txts = append(txts, strings.Join(txt, ""))
+		}
+	}
+	return txts, nil
+}
+
+// LookupSRV tries to resolve an SRV query of the given service, protocol, and domain name.
+// The proto is "tcp" or "udp". The returned records are sorted by priority and randomized
+// by weight within a priority, as specified by RFC 2782.
+//
+// LookupSRV constructs the DNS name to look up following RFC 2782. That is, it looks up
+// _service._proto.name. To accommodate services publishing SRV records under non-standard
+// names, if both service and proto are empty strings, LookupSRV looks up name directly.
+func (r *Dialer) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	target := name
+	if service != "" || proto != "" {
+		target = "_" + service + "._" + proto + "." + name
+	}
+
+	records, err := r.lookupTyped(ctx, target, TypeSRV)
+	if err != nil {
+		return "", nil, err
+	}
+
+	srvs := make([]*net.SRV, 0, len(records))
+	for _, record := range records {
+		if srv, ok := record.data().(SRVData); ok {
+			srvs = append(srvs, &net.SRV{
+				Target:   srv.Target,
+				Port:     srv.Port,
+				Priority: srv.Priority,
+				Weight:   srv.Weight,
+			})
+		}
+	}
+
+	sortSRV(srvs)
+	return dns.Fqdn(target), srvs, nil
+}
+
+// LookupNS returns the DNS NS records for the given domain name.
+func (r *Dialer) LookupNS(ctx context.Context, name string) ([]*net.NS, error) {
+	records, err := r.lookupTyped(ctx, name, TypeNS)
+	if err != nil {
+		return nil, err
+	}
+
+	nss := make([]*net.NS, 0, len(records))
+	for _, record := range records {
+		if ns, ok := record.data().(NSData); ok {
+			nss = append(nss, &net.NS{Host: ns.Host})
+		}
+	}
+	return nss, nil
+}
+
+// LookupAddr performs a reverse lookup for the given address, returning a list of names
+// mapping to that address.
+func (r *Dialer) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	reverse, err := dns.ReverseAddr(addr)
+	if err != nil {
+		return nil, &net.DNSError{Err: "unrecognized address", Name: addr}
+	}
+
+	records, err := r.lookupTyped(ctx, strings.TrimSuffix(reverse, "."), TypePTR)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(records))
+	for _, record := range records {
+		if ptr, ok := record.data().(PTRData); ok {
+			names = append(names, ptr.Target)
+		}
+	}
+	return names, nil
+}
+
+// lookupTyped looks up the records of qtype for name, reporting failures the way
+// net.Resolver does.
+func (r *Dialer) lookupTyped(ctx context.Context, name string, qtype RecordType) ([]Record, error) {
+	if err := r.checkUsable(); err != nil {
+		return nil, err
+	}
+
+	records, err := r.lookupRecords(ctx, name, qtype)
+	if err != nil {
+		return nil, newDNSError(name, err)
+	}
+	return records, nil
+}
+
+// checkUsable returns an error if the Dialer can't be used for lookups, because of a
+// configuration error or because it has been closed.
+func (r *Dialer) checkUsable() error {
+	if r.err != nil {
+		return fmt.Errorf("invalid configuration: %w", r.err)
+	}
+	if r.ctx.Err() != nil {
+		return fmt.Errorf("dialer closed: %w", net.ErrClosed)
+	}
+	return nil
+}
+
+// filterIPFamily returns the addresses of ips that belong to network, "ip4" or "ip6", or
+// all of them for "ip". Like net.Resolver, it fails if that leaves no addresses. The
+// result is always a new slice, as ips may be shared with the cache and other callers.
+func filterIPFamily(network, host string, ips []net.IP) ([]net.IP, error) {
+	if network == "ip" {
+		return slices.Clone(ips), nil
+	}
+
+	filtered := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		if (ip.To4() != nil) == (network == "ip4") {
+			filtered = append(filtered, ip)
+		}
+	}
+	if len(filtered) == 0 {
+		return nil, &net.DNSError{Err: "no suitable address found", Name: host}
+	}
+	return filtered, nil
+}
+
+// sortSRV sorts SRV records by priority, and within each priority orders them randomly
+// with a chance proportional to their weight (RFC 2782).
+func sortSRV(srvs []*net.SRV) {
+	sort.Slice(srvs, func(i, j int) bool {
+		if srvs[i].Priority == srvs[j].Priority {
+			return srvs[i].Weight < srvs[j].Weight
+		}
+		return srvs[i].Priority < srvs[j].Priority
+	})
+
+	start := 0
+	for i := 1; i <= len(srvs); i++ {
+		if i == len(srvs) || srvs[i].Priority != srvs[start].Priority {
+			shuffleByWeight(srvs[start:i])
+			start = i
+		}
+	}
+}
+
+// shuffleByWeight orders srvs, all of the same priority, by repeatedly picking one at
+// random with a chance proportional to its weight.
+func shuffleByWeight(srvs []*net.SRV) {
+	sum := 0
+	for _, srv := range srvs {
+		sum += int(srv.Weight)
+	}
+
+	for sum > 0 && len(srvs) > 1 {
+		s := 0
+		n := rand.IntN(sum)
+		for i := range srvs {
+			s += int(srvs[i].Weight)
+			if s > n {
+				if i > 0 {
+					srvs[0], srvs[i] = srvs[i], srvs[0]
+				}
+				break
+			}
+		}
+		sum -= int(srvs[0].Weight)
+		srvs = srvs[1:]
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startTestZoneServer starts a local DNS server answering from a small fixed zone, and
+// returns its address along with a counter of the queries it received.
+func startTestZoneServer(t *testing.T) (string, *atomic.Int32) {
+	zone := map[uint16][]string{
+		dns.TypeA:     {"example.com. 60 IN A 192.0.2.1"},
+		dns.TypeAAAA:  {"example.com. 60 IN AAAA 2001:db8::1"},
+		dns.TypeMX:    {"example.com. 60 IN MX 20 mx2.example.com.", "example.com. 60 IN MX 10 mx1.example.com."},
+		dns.TypeTXT:   {`example.com. 60 IN TXT "v=spf1 -all"`},
+		dns.TypeNS:    {"example.com. 60 IN NS ns1.example.com."},
+		dns.TypeSRV:   {"_sip._tcp.example.com. 60 IN SRV 10 5 5060 sip.example.com."},
+		dns.TypePTR:   {"1.2.0.192.in-addr.arpa. 60 IN PTR example.com."},
+		dns.TypeCNAME: {"www.example.com. 60 IN CNAME example.com."},
+	}
+
+	var queries atomic.Int32
+	addr := startTestDNSServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		queries.Add(1)
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+
+		q := req.Question[0]
+		resp.Answer = zoneRecords(zone, q.Name, q.Qtype)
+
+		// Like an authoritative server, answer for an alias with its CNAME, and leave it
+		// to the client to follow it
+		if len(resp.Answer) == 0 && q.Qtype != dns.TypeCNAME {
+			resp.Answer = zoneRecords(zone, q.Name, dns.TypeCNAME)
+		}
+		_ = w.WriteMsg(resp)
+	})
+	return addr, &queries
+}
+
+// zoneRecords returns the records of qtype owned by name in zone.
+func zoneRecords(zone map[uint16][]string, name string, qtype uint16) []dns.RR {
+	var rrs []dns.RR
+	for _, s := range zone[qtype] {
+		rr, err := dns.NewRR(s)
+		if err == nil && dns.CanonicalName(rr.Header().Name) == dns.CanonicalName(name) {
+			rrs = append(rrs, rr)
+		}
+	}
+	return rrs
+}
+
+func TestDialer_LookupMethods(t *testing.T) {
+	addr, _ := startTestZoneServer(t)
+	dialer := New(WithResolvers(addr))
+	defer dialer.Close()
+	ctx := context.Background()
+
+	hosts, err := dialer.LookupHost(ctx, "example.com")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"192.0.2.1", "2001:db8::1"}, hosts)
+
+	ips, err := dialer.LookupIP(ctx, "ip4", "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []net.IP{net.ParseIP("192.0.2.1")}, ips)
+
+	addrs, err := dialer.LookupNetIP(ctx, "ip6", "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("2001:db8::1")}, addrs)
+
+	ipAddrs, err := dialer.LookupIPAddr(ctx, "192.0.2.7")
+	require.NoError(t, err)
+	assert.Equal(t, []net.IPAddr{{IP: net.ParseIP("192.0.2.7")}}, ipAddrs)
+
+	cname, err := dialer.LookupCNAME(ctx, "www.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "example.com.", cname)
+
+	// Not an alias, but it has addresses, so it's its own canonical name
+	cname, err = dialer.LookupCNAME(ctx, "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "example.com.", cname)
+
+	mxs, err := dialer.LookupMX(ctx, "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []*net.MX{{Host: "mx1.example.com.", Pref: 10}, {Host: "mx2.example.com.", Pref: 20}}, mxs)
+
+	txts, err := dialer.LookupTXT(ctx, "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"v=spf1 -all"}, txts)
+
+	name, srvs, err := dialer.LookupSRV(ctx, "sip", "tcp", "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "_sip._tcp.example.com.", name)
+	assert.Equal(t, []*net.SRV{{Target: "sip.example.com.", Port: 5060, Priority: 10, Weight: 5}}, srvs)
+
+	nss, err := dialer.LookupNS(ctx, "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []*net.NS{{Host: "ns1.example.com."}}, nss)
+
+	names, err := dialer.LookupAddr(ctx, "192.0.2.1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"example.com."}, names)
+}
+
+func TestDialer_LookupMethods_Errors(t *testing.T) {
+	addr, _ := startTestZoneServer(t)
+	dialer := New(WithResolvers(addr))
+	defer dialer.Close()
+	ctx := context.Background()
+
+	_, err := dialer.LookupMX(ctx, "_sip._tcp.example.com")
+	var dnsErr *net.DNSError
+	require.ErrorAs(t, err, &dnsErr)
+	assert.True(t, dnsErr.IsNotFound)
+	assert.ErrorIs(t, err, ErrNoData)
+
+	_, err = dialer.LookupIP(ctx, "tcp", "example.com")
+	assert.Equal(t, net.UnknownNetworkError("tcp"), err)
+
+	_, err = dialer.LookupAddr(ctx, "not-an-ip")
+	assert.ErrorAs(t, err, &dnsErr)
+}
+
+func TestDialer_LookupCNAME_StaticHosts(t *testing.T) {
+	dialer := New(
+		WithCustomResolvers(&mockResolver{name: "dns", err: errors.New("must not be queried")}),
+		WithStaticHosts(map[string][]netip.Addr{
+			"db.internal": {netip.MustParseAddr("10.0.0.5")},
+		}),
+	)
+	defer dialer.Close()
+
+	cname, err := dialer.LookupCNAME(context.Background(), "db.internal")
+	require.NoError(t, err)
+	assert.Equal(t, "db.internal.", cname)
+}
+
+func TestDialer_LookupCNAME_UnnamedRecords(t *testing.T) {
+	// The resolver leaves the owner name of its records empty
+	dialer := New(WithCustomResolvers(&mockResolver{
+		name:     "custom",
+		response: []Record{{Type: TypeA, Value: "192.0.2.1", TTL: 60}},
+	}))
+	defer dialer.Close()
+
+	cname, err := dialer.LookupCNAME(context.Background(), "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "example.com.", cname)
+}
+
+func TestDialer_LookupCNAME_SearchDomains(t *testing.T) {
+	addr, _ := startTestZoneServer(t)
+	dialer := New(
+		WithResolvers(addr),
+		WithSearchDomains("example.com"),
+	)
+	defer dialer.Close()
+
+	cname, err := dialer.LookupCNAME(context.Background(), "www")
+	require.NoError(t, err)
+	assert.Equal(t, "example.com.", cname)
+}
+
+func TestDialer_LookupMX_Cached(t *testing.T) {
+	addr, queries := startTestZoneServer(t)
+	dialer := New(
+		WithResolvers(addr),
+		WithCache(10, time.Second, time.Minute),
+	)
+	defer dialer.Close()
+
+	for i := 0; i < 3; i++ {
+		mxs, err := dialer.LookupMX(context.Background(), "example.com")
+		require.NoError(t, err)
+		assert.Len(t, mxs, 2)
+	}
+	assert.Equal(t, int32(1), queries.Load())
+}
+
+func TestDialer_LookupIP_DoesNotAliasCache(t *testing.T) {
+	addr, queries := startTestZoneServer(t)
+	dialer := New(
+		WithResolvers(addr),
+		WithCache(10, time.Second, time.Minute),
+	)
+	defer dialer.Close()
+	ctx := context.Background()
+
+	// The first lookup is a cache miss, so it gets the very slice that was just cached
+	ips, err := dialer.LookupIP(ctx, "ip", "example.com")
+	require.NoError(t, err)
+	require.Len(t, ips, 2)
+	ips[0], ips[1] = net.ParseIP("198.51.100.1"), net.ParseIP("198.51.100.2")
+
+	hosts, err := dialer.LookupHost(ctx, "example.com")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"192.0.2.1", "2001:db8::1"}, hosts)
+	assert.Equal(t, int32(2), queries.Load())
+}
+
+func TestSortSRV(t *testing.T) {
+	srvs := []*net.SRV{
+		{Target: "c.", Priority: 20, Weight: 1},
+		{Target: "a.", Priority: 10, Weight: 0},
+		{Target: "b.", Priority: 10, Weight: 100},
+	}
+	sortSRV(srvs)
+
+	// The zero weight record only has a chance once the others have been picked
+	assert.Equal(t, "b.", srvs[0].Target)
+	assert.Equal(t, "a.", srvs[1].Target)
+	assert.Equal(t, "c.", srvs[2].Target)
+}
+
+func TestDialer_Query(t *testing.T) {
+	addr, queries := startTestZoneServer(t)
+	logger := &mockLogger{}
+	dialer := New(
+		WithResolvers(addr),
+		WithCache(10, time.Second, time.Minute),
+		WithNegativeCache(time.Second, time.Minute),
+		WithLogger(logger),
+	)
+	defer dialer.Close()
+	ctx := context.Background()
+
+	records, err := dialer.Query(ctx, "example.com", TypeSRV)
+	require.ErrorIs(t, err, ErrNoData)
+	assert.Nil(t, records)
+
+	records, err = dialer.Query(ctx, "_sip._tcp.example.com", TypeSRV)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, TypeSRV, records[0].Type)
+	assert.Equal(t, "10 5 5060 sip.example.com.", records[0].Value)
+
+	// Served from the cache the second time around
+	_, err = dialer.Query(ctx, "_sip._tcp.example.com", TypeSRV)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), queries.Load())
+	assert.Contains(t, logger.logs, "DEBUG: record cache hit")
+}
@@ -0,0 +1,172 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// newQuery constructs a recursive DNS query message for a single host and record type.
+//
+// Every transport sends the same question, only the framing on the wire differs, so
+// resolvers build their messages here and hand them to their own exchange logic.
+func newQuery(host string, qtype RecordType) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(host), uint16(qtype)) // dns.Fqdn ensures trailing dot (e.g., "example.com.")
+	msg.RecursionDesired = true                    // Ask the server to recursively resolve if it doesn't have the answer cached
+	return msg
+}
+
+// responseMatches reports whether response is the answer to query.
+//
+// Over UDP anyone can send us a packet, and a reused socket can still receive late replies
+// to an earlier query that timed out. Only a response with the same message ID and the same
+// question (name compared case-insensitively, since servers may echo it with different
+// casing) belongs to our query, everything else has to be discarded.
+func responseMatches(query, response *dns.Msg) bool {
+	if !response.Response || response.Id != query.Id {
+		return false
+	}
+	if len(response.Question) != 1 || len(query.Question) != 1 {
+		return false
+	}
+
+	q, rq := query.Question[0], response.Question[0]
+	return q.Qtype == rq.Qtype &&
+		q.Qclass == rq.Qclass &&
+		strings.EqualFold(q.Name, rq.Name)
+}
+
+// parseResponse converts a DNS response message into our Record format.
+//
+// It is transport-agnostic: UDP, TCP and any other resolver implementation pass the
+// message they received here once the exchange itself has succeeded.
+func parseResponse(response *dns.Msg) ([]Record, error) {
+	// Check DNS response code. RcodeSuccess (0) means the query succeeded. Other codes include
+	// NXDomain (domain doesn't exist), ServFail (server error), etc. NXDOMAIN is an answer
+	// rather than a failure, so it gets its own error that can be cached.
+	if response.Rcode == dns.RcodeNameError {
+		return nil, newNotFoundError(response, true)
+	}
+	if response.Rcode != dns.RcodeSuccess {
+		return nil, &RcodeError{Rcode: response.Rcode}
+	}
+
+	// Parse the answer section into our Record format. The DNS response contains raw resource
+	// records that we need to convert into a more usable structure.
+	var records []Record
+	for _, ans := range response.Answer {
+		record := Record{
+			Name: ans.Header().Name,
+			Type: RecordType(ans.Header().Rrtype),
+			TTL:  ans.Header().Ttl,
+		}
+
+		// Extract the value based on record type. Each DNS record type has its own struct
+		// in miekg/dns, so we use a type switch to handle them.
+		switch a := ans.(type) {
+		// Besides the string Value, we keep the typed data so callers don't have to parse it
+		// back out of the string, which for TXT records wouldn't even be possible reliably.
+		case *dns.A:
+			// IPv4 address (e.g., "93.184.216.34")
+			record.Value = a.A.String()
+			if addr, ok := netip.AddrFromSlice(a.A); ok {
+				record.Data = AData{Addr: addr.Unmap()}
+			}
+		case *dns.AAAA:
+			// IPv6 address (e.g., "2606:2800:220:1:248:1893:25c8:1946")
+			record.Value = a.AAAA.String()
+			if addr, ok := netip.AddrFromSlice(a.AAAA); ok {
+				record.Data = AAAAData{Addr: addr}
+			}
+		case *dns.CNAME:
+			// Canonical name / alias (e.g., "www.example.com.")
+			record.Value = a.Target
+			record.Data = CNAMEData{Target: a.Target}
+		case *dns.MX:
+			// Mail exchange, includes priority and mailserver
+			// Format: "priority mailserver" (e.g., "10 mail.example.com.")
+			record.Value = fmt.Sprintf("%d %s", a.Preference, a.Mx)
+			record.Data = MXData{Preference: a.Preference, Host: a.Mx}
+		case *dns.NS:
+			// Name server (e.g., "ns1.example.com.")
+			record.Value = a.Ns
+			record.Data = NSData{Host: a.Ns}
+		case *dns.TXT:
+			// Text record, can contain multiple strings, we format as a single string
+			record.Value = fmt.Sprintf("%v", a.Txt)
+			record.Data = TXTData(append([]string(nil), a.Txt...))
+		case *dns.SOA:
+			// Start of Authority, contains zone metadata
+			// Format: "ns mbox serial refresh retry expire minttl"
+			record.Value = fmt.Sprintf("%s %s %d %d %d %d %d",
+				a.Ns, a.Mbox, a.Serial, a.Refresh, a.Retry, a.Expire, a.Minttl)
+			record.Data = SOAData{
+				NS:      a.Ns,
+				Mbox:    a.Mbox,
+				Serial:  a.Serial,
+				Refresh: a.Refresh,
+				Retry:   a.Retry,
+				Expire:  a.Expire,
+				MinTTL:  a.Minttl,
+			}
+		case *dns.PTR:
+			// Pointer record, used for reverse DNS lookups
+			record.Value = a.Ptr
+			record.Data = PTRData{Target: a.Ptr}
+		case *dns.SRV:
+			// Service record, used for service discovery
+			// Format: "priority weight port target"
+			record.Value = fmt.Sprintf("%d %d %d %s",
+				a.Priority, a.Weight, a.Port, a.Target)
+			record.Data = SRVData{Priority: a.Priority, Weight: a.Weight, Port: a.Port, Target: a.Target}
+		default:
+			// For record types we don't explicitly handle, use the library's string representation.
+			// This provides basic support for any record type without requiring explicit handling
+			// for each one.
+			record.Value = ans.String()
+		}
+
+		records = append(records, record)
+	}
+
+	// Some DNS resolvers return RcodeSuccess with an empty answer section when a record
+	// exists but has no data (e.g., a domain with no A records). We treat this as an
+	// error rather than returning an empty slice, to distinguish it from never calling
+	// this function vs calling it and getting nothing.
+	if len(records) == 0 {
+		return nil, newNotFoundError(response, false)
+	}
+
+	return records, nil
+}
+
+// newNotFoundError builds the error for a negative response, NXDOMAIN or NODATA.
+//
+// Per RFC 2308, Section 5, a negative answer may be cached for the lesser of the TTL of
+// the SOA record in the authority section and the SOA's MINIMUM field. Without an SOA the
+// server told us nothing about how long the answer holds, so it must not be cached.
+func newNotFoundError(response *dns.Msg, nxdomain bool) *NotFoundError {
+	err := &NotFoundError{NXDomain: nxdomain}
+	if len(response.Question) > 0 {
+		err.Host = strings.TrimSuffix(response.Question[0].Name, ".")
+	}
+
+	for _, rr := range response.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			ttl := min(soa.Hdr.Ttl, soa.Minttl)
+			err.ttl = time.Duration(ttl) * time.Second
+			break
+		}
+	}
+
+	return err
+}
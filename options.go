@@ -5,7 +5,14 @@
 
 package dnsdialer
 
-import "time"
+import (
+	"crypto/tls"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+)
 
 // Option is a function that configures a Dialer.
 //
@@ -22,6 +29,15 @@ type Option func(*Dialer)
 // - IP with port: "8.8.8.8:53"
 // - IP without port: "8.8.8.8" (port 53 is assumed)
 // - Hostname with port: "dns.google:53"
+// - A URL selecting the transport:
+//   - "udp://8.8.8.8:53": UDP, the same as a plain address
+//   - "tcp://8.8.8.8:53": TCP
+//   - "tls://1.1.1.1:853#cloudflare-dns.com": DNS-over-TLS, the optional fragment sets the TLS server name
+//   - "https://dns.google/dns-query": DNS-over-HTTPS
+//   - "quic://94.140.14.140:853#dns.adguard-dns.com": DNS-over-QUIC, the optional fragment sets the TLS server name
+//
+// Addresses with an unknown scheme or that can't be parsed are a configuration
+// error, reported by Dialer.Err and returned from every DialContext call.
 //
 // The order matters for the Fallback strategy (tries in order), but not for
 // Race (queries all simultaneously) or Consensus (queries all and compares).
@@ -29,12 +45,195 @@ type Option func(*Dialer)
 // Example:
 //
 //	dialer := New(
-//	    WithResolvers("8.8.8.8", "1.1.1.1", "9.9.9.9"),
+//	    WithResolvers("8.8.8.8", "tls://1.1.1.1#cloudflare-dns.com", "https://dns.google/dns-query"),
 //	)
 func WithResolvers(addrs ...string) Option {
 	return func(r *Dialer) {
 		for _, addr := range addrs {
-			r.resolvers = append(r.resolvers, newUDPResolver(addr, r.timeout, r.poolSize))
+			res, err := newResolverFromAddr(addr, r.timeout, r.poolSize)
+			if err != nil {
+				// Keep the first error, it's usually the most helpful one to report
+				if r.err == nil {
+					r.err = err
+				}
+				continue
+			}
+			r.resolvers = append(r.resolvers, res)
+		}
+	}
+}
+
+// WithTCPResolvers sets DNS resolvers to query over TCP instead of UDP.
+//
+// Addresses use the same format as WithResolvers. Queries to each resolver are
+// pipelined over a small pool of persistent connections (see WithConnPoolSize),
+// so TCP doesn't cost a handshake per query once connections are established.
+//
+// UDP resolvers configured through WithResolvers already retry over TCP when a
+// response is truncated, this option is for networks where UDP is blocked or
+// unreliable.
+//
+// Example:
+//
+//	dialer := New(
+//	    WithTCPResolvers("8.8.8.8", "1.1.1.1"),
+//	)
+func WithTCPResolvers(addrs ...string) Option {
+	return func(r *Dialer) {
+		for _, addr := range addrs {
+			r.resolvers = append(r.resolvers, newTCPResolver(addr, r.timeout, r.poolSize))
+		}
+	}
+}
+
+// WithDoTResolvers sets DNS-over-TLS (RFC 7858) resolvers to query.
+//
+// Queries are encrypted, so nothing about the names being resolved leaves the host
+// in plaintext. serverName is used for SNI and to verify the server's certificate,
+// e.g. "cloudflare-dns.com" for 1.1.1.1. If empty, the certificate is verified
+// against the host part of each address instead.
+//
+// Each address can be an IP or hostname, with or without port (port 853 is assumed).
+// Connections are kept open and reused across queries, and TLS sessions are resumed
+// on reconnect. DoT resolvers work with every Strategy and can be mixed with plain
+// UDP resolvers.
+//
+// Example:
+//
+//	dialer := New(
+//	    WithDoTResolvers("cloudflare-dns.com", "1.1.1.1:853", "1.0.0.1:853"),
+//	    WithDoTResolvers("dns.google", "8.8.8.8:853"),
+//	)
+func WithDoTResolvers(serverName string, addrs ...string) Option {
+	return WithDoTResolversTLS(&tls.Config{ServerName: serverName}, addrs...)
+}
+
+// WithDoTResolversTLS sets DNS-over-TLS (RFC 7858) resolvers to query using a custom
+// TLS configuration.
+//
+// Use this instead of WithDoTResolvers to configure custom root CAs, client
+// certificates, or SPKI pinning through VerifySPKIPins. The config is cloned, so it
+// can be shared safely. If it has no ClientSessionCache, one is created so TLS
+// sessions can be resumed.
+//
+// Example:
+//
+//	dialer := New(
+//	    WithDoTResolversTLS(&tls.Config{
+//	        ServerName:       "dns.quad9.net",
+//	        VerifyConnection: VerifySPKIPins("<base64 sha256 pin>"),
+//	    }, "9.9.9.9"),
+//	)
+func WithDoTResolversTLS(cfg *tls.Config, addrs ...string) Option {
+	return func(r *Dialer) {
+		for _, addr := range addrs {
+			r.resolvers = append(r.resolvers, newDoTResolver(addr, cfg, r.timeout, r.poolSize))
+		}
+	}
+}
+
+// DoHConfig configures DNS-over-HTTPS resolvers.
+type DoHConfig struct {
+	// Client is the HTTP client used to send queries. If nil, a client is created whose
+	// transport negotiates HTTP/2 and keeps up to the connection pool size idle
+	// connections per endpoint. A client passed in here is left as is by Dialer.Close,
+	// it may be shared with other users.
+	Client *http.Client
+
+	// UsePOST, when true, sends queries in the body of POST requests. By default queries
+	// are sent as GET requests, which HTTP caches between us and the server can answer.
+	UsePOST bool
+}
+
+// WithDoHResolvers sets DNS-over-HTTPS (RFC 8484) resolvers to query.
+//
+// Each URL is the full endpoint, including the path (usually "/dns-query"). DoH
+// resolvers work with every Strategy and can be mixed with plain UDP resolvers,
+// for example to race an encrypted resolver against a local one.
+//
+// Example:
+//
+//	dialer := New(
+//	    WithDoHResolvers("https://dns.google/dns-query", "https://cloudflare-dns.com/dns-query"),
+//	    WithResolvers("8.8.8.8"),
+//	    WithStrategy(Race{}),
+//	)
+func WithDoHResolvers(urls ...string) Option {
+	return WithDoHResolversConfig(DoHConfig{}, urls...)
+}
+
+// WithDoHResolversConfig sets DNS-over-HTTPS (RFC 8484) resolvers to query using a
+// custom configuration, e.g. a custom http.Client or POST requests.
+//
+// Example:
+//
+//	dialer := New(
+//	    WithDoHResolversConfig(DoHConfig{
+//	        Client:  &http.Client{Transport: myTransport},
+//	        UsePOST: true,
+//	    }, "https://dns.google/dns-query"),
+//	)
+func WithDoHResolversConfig(cfg DoHConfig, urls ...string) Option {
+	return func(r *Dialer) {
+		for _, url := range urls {
+			r.resolvers = append(r.resolvers, newDoHResolver(url, cfg, r.timeout, r.poolSize))
+		}
+	}
+}
+
+// WithDoQResolvers sets DNS-over-QUIC (RFC 9250) resolvers to query.
+//
+// Each query runs on its own QUIC stream over a single persistent connection per
+// resolver, so packet loss only delays the affected query. This makes DoQ a good
+// fit for lossy networks. When reconnecting, queries are sent as 0-RTT data if the
+// server supports it.
+//
+// serverName is used for SNI and certificate verification, as with WithDoTResolvers.
+// Each address can be an IP or hostname, with or without port (port 853 is assumed).
+//
+// Example:
+//
+//	dialer := New(
+//	    WithDoQResolvers("dns.adguard-dns.com", "94.140.14.140:853"),
+//	    WithResolvers("8.8.8.8"),
+//	    WithStrategy(Fallback{}),
+//	)
+func WithDoQResolvers(serverName string, addrs ...string) Option {
+	return WithDoQResolversTLS(&tls.Config{ServerName: serverName}, addrs...)
+}
+
+// WithDoQResolversTLS sets DNS-over-QUIC (RFC 9250) resolvers to query using a custom
+// TLS configuration.
+//
+// The config is cloned, and its NextProtos is always set to "doq" as required by the
+// protocol. VerifySPKIPins can be used for pinning, as with DNS-over-TLS.
+func WithDoQResolversTLS(cfg *tls.Config, addrs ...string) Option {
+	return func(r *Dialer) {
+		for _, addr := range addrs {
+			r.resolvers = append(r.resolvers, newDoQResolver(addr, cfg, r.timeout))
+		}
+	}
+}
+
+// WithCustomResolvers adds user-provided Resolver implementations.
+//
+// Custom resolvers participate in every Strategy exactly like the built-in ones,
+// and can be combined with them. Their position among the configured resolvers
+// follows the order of the options, which matters for Fallback.
+//
+// Example:
+//
+//	dialer := New(
+//	    WithCustomResolvers(registryResolver),
+//	    WithResolvers("8.8.8.8"),
+//	    WithStrategy(Fallback{}),
+//	)
+func WithCustomResolvers(resolvers ...Resolver) Option {
+	return func(r *Dialer) {
+		for _, res := range resolvers {
+			if res != nil {
+				r.resolvers = append(r.resolvers, res)
+			}
 		}
 	}
 }
@@ -103,13 +302,17 @@ func WithLogger(l Logger) Option {
 	}
 }
 
-// WithConnPoolSize sets the maximum number of pooled connections per resolver.
+// WithConnPoolSize sets the maximum number of sockets or connections per resolver.
 //
-// Connection pooling reduces socket creation/destruction overhead. Each DNS resolver
-// gets its own pool. Higher values reduce the chance of creating new connections
-// under load, but consume more file descriptors.
+// UDP, TCP and DNS-over-TLS resolvers multiplex queries over up to size sockets, many
+// queries share a socket and are told apart by message ID. A new socket is only opened
+// when all existing ones are busy. Each socket carries at most 128 queries in flight, so
+// a resolver has at most size*128 queries waiting for a response. A query beyond that
+// waits for one of them to finish, and fails with a timeout if its deadline passes first.
+// For DNS-over-HTTPS, size is the number of idle connections kept open.
 //
-// Default is 4 connections per resolver if not specified.
+// Higher values spread load over more sockets and raise the bound on queries in flight,
+// but consume more file descriptors. Default is 4 per resolver if not specified.
 //
 // Example:
 //
@@ -148,6 +351,338 @@ func WithConnPoolSize(size int) Option {
 //	)
 func WithCache(size int, minTTL, maxTTL time.Duration) Option {
 	return func(r *Dialer) {
-		r.cache = newDNSCache(size, minTTL, maxTTL)
+		r.cacheConfig.size = size
+		r.cacheConfig.minTTL = minTTL
+		r.cacheConfig.maxTTL = maxTTL
+	}
+}
+
+// WithServeStale keeps cached answers around for window after they expire, and serves
+// them when resolving the host again fails (RFC 8767).
+//
+// Without it, a dial fails as soon as every resolver is unreachable, even if the cache
+// held a perfectly good answer that expired seconds ago. With it, the expired answer is
+// returned instead and refreshed in the background until the resolvers answer again.
+// While resolution keeps failing, stale answers are served right away rather than after
+// waiting on the resolvers each time.
+//
+// Serve-stale only has an effect together with WithCache. Stale answers are logged at
+// info level with a "stale" field, so you can tell when they are being served.
+//
+// Example:
+//
+//	// Keep dialing known hosts through resolver outages of up to an hour
+//	dialer := New(
+//	    WithResolvers("8.8.8.8", "1.1.1.1"),
+//	    WithCache(1000, 1*time.Second, 5*time.Minute),
+//	    WithServeStale(1*time.Hour),
+//	)
+func WithServeStale(window time.Duration) Option {
+	return func(r *Dialer) {
+		if window > 0 {
+			r.cacheConfig.staleWindow = window
+		}
+	}
+}
+
+// WithNegativeCache caches negative answers, NXDOMAIN and NODATA, so repeated dials to a
+// host that doesn't exist don't query every resolver each time (RFC 2308).
+//
+// How long a negative answer is cached comes from the SOA record the server includes in
+// it, clamped between minTTL and maxTTL. Answers without an SOA aren't cached. Dials
+// answered from the negative cache fail with a *NotFoundError whose Cached field is set.
+//
+// Negative caching only has an effect together with WithCache, and shares its size.
+//
+// Example:
+//
+//	dialer := New(
+//	    WithResolvers("8.8.8.8", "1.1.1.1"),
+//	    WithCache(1000, 1*time.Second, 5*time.Minute),
+//	    WithNegativeCache(1*time.Second, 1*time.Minute),
+//	)
+func WithNegativeCache(minTTL, maxTTL time.Duration) Option {
+	return func(r *Dialer) {
+		if maxTTL > 0 {
+			r.cacheConfig.negMinTTL = minTTL
+			r.cacheConfig.negMaxTTL = maxTTL
+		}
+	}
+}
+
+// WithMaxCNAMEDepth sets the maximum number of CNAMEs followed when resolving a host.
+//
+// Most resolvers answer a question for an alias with the whole CNAME chain, but some only
+// return part of it. The Dialer follows the rest of the chain itself, asking the same
+// resolver for each name it leads to, and stops with an error once the chain gets longer
+// than depth or loops back on itself.
+//
+// Default is 8 if not specified.
+func WithMaxCNAMEDepth(depth int) Option {
+	return func(r *Dialer) {
+		if depth > 0 {
+			r.maxCNAMEDepth = depth
+		}
+	}
+}
+
+// WithSearchDomains sets the search list used to expand relative host names, like the
+// search directive in resolv.conf.
+//
+// In environments like Kubernetes, services are dialed by short names such as "payments",
+// which only resolve once a domain from the search list is appended. Which names are tried,
+// and in what order, follows glibc's rules, see WithNdots. The first name that exists is
+// used, and it's cached under the expanded name.
+//
+// Example:
+//
+//	dialer := New(
+//	    WithResolvers("10.96.0.10"),
+//	    WithSearchDomains("default.svc.cluster.local", "svc.cluster.local", "cluster.local"),
+//	    WithNdots(5),
+//	)
+func WithSearchDomains(domains ...string) Option {
+	return func(r *Dialer) {
+		for _, domain := range domains {
+			domain = strings.Trim(domain, ".")
+			if domain != "" {
+				r.searchDomains = append(r.searchDomains, domain)
+			}
+		}
+	}
+}
+
+// WithNdots sets the number of dots a host name needs to be tried as is before the search
+// list, like the ndots option in resolv.conf.
+//
+// A name with fewer dots is tried with each of the search domains appended first, and as
+// is last. A name with at least n dots is tried as is first. Names ending in a dot are
+// fully qualified and never expanded. Values above 15 are capped, as glibc does.
+//
+// Default is 1 if not specified.
+func WithNdots(n int) Option {
+	return func(r *Dialer) {
+		if n >= 0 {
+			r.ndots = min(n, maxNdots)
+		}
+	}
+}
+
+// WithStaticHosts sets fixed addresses for host names, which are returned without
+// querying any resolver, e.g. to point a name at a different backend in staging.
+//
+// Static hosts take precedence over the hosts file set with WithHostsFile, and both over
+// DNS. Names are matched case-insensitively, as given, before any search domain is
+// applied. A name mapped to no addresses is ignored. Calling it more than once adds to the
+// hosts set before, replacing the addresses of names set again.
+//
+// Example:
+//
+//	dialer := New(
+//	    WithResolvers("8.8.8.8"),
+//	    WithStaticHosts(map[string][]netip.Addr{
+//	        "api.example.com": {netip.MustParseAddr("10.0.0.10")},
+//	    }),
+//	)
+func WithStaticHosts(hosts map[string][]netip.Addr) Option {
+	return func(r *Dialer) {
+		if r.staticHosts == nil {
+			r.staticHosts = make(map[string][]netip.Addr, len(hosts))
+		}
+		for host, addrs := range hosts {
+			if len(addrs) == 0 {
+				continue
+			}
+			unmapped := make([]netip.Addr, len(addrs))
+			for i, addr := range addrs {
+				unmapped[i] = addr.Unmap()
+			}
+			r.staticHosts[hostsKey(host)] = unmapped
+		}
+	}
+}
+
+// WithHostsFile makes the Dialer answer lookups for names listed in a hosts file, like
+// /etc/hosts, before querying any resolver. Use an empty path for /etc/hosts.
+//
+// The file is read on first use, and checked for changes every 5 seconds after that, so
+// edits are picked up without recreating the Dialer. A missing file simply has no
+// entries. Static hosts set with WithStaticHosts take precedence over the file.
+//
+// Example:
+//
+//	dialer := New(
+//	    WithResolvers("8.8.8.8"),
+//	    WithHostsFile(""),
+//	)
+func WithHostsFile(path string) Option {
+	return func(r *Dialer) {
+		if path == "" {
+			path = defaultHostsPath
+		}
+		r.hostsFile = newHostsFile(path)
+	}
+}
+
+// WithConnectionAttemptDelay sets how long DialContext waits for a connection attempt to
+// an address before trying the next address in parallel, as in Happy Eyeballs (RFC 8305).
+//
+// When a host resolves to several addresses, IPv4 and IPv6 addresses take turns, and
+// attempts are staggered by delay rather than made one after the other, so a blackholed
+// address doesn't stall the dial for the full connect timeout. The first connection wins
+// and the other attempts are canceled. A negative delay disables racing, the next address
+// is then only tried once the previous attempt failed.
+//
+// For "tcp" dials, attempts start as soon as the first address family is answered, rather
+// than after both the A and AAAA queries completed. If IPv4 is answered first, it waits up
+// to 50ms for IPv6 (the Resolution Delay of RFC 8305), the other way around with
+// PreferIPv4. The addresses answered later join the attempts still to be made.
+//
+// Default is 250ms, as recommended by RFC 8305, if not specified.
+func WithConnectionAttemptDelay(delay time.Duration) Option {
+	return func(r *Dialer) {
+		r.attemptDelay = delay
+	}
+}
+
+// WithAddressPreference sets the order in which DialContext tries the addresses a host
+// resolves to: RFC6724, PreferIPv4 or PreferIPv6. With both address families, attempts
+// alternate between them, starting with the family of the most preferred address.
+//
+// Default is RFC6724 if not specified.
+func WithAddressPreference(pref AddressPreference) Option {
+	return func(r *Dialer) {
+		r.addrPreference = pref
+	}
+}
+
+// WithPolicyTable replaces the RFC 6724 policy table used to order addresses with the
+// RFC6724 address preference, e.g. to prefer IPv4 the way RFC 6724 section 10.3 describes
+// by raising the precedence of ::ffff:0:0/96. The default is the table of RFC 6724
+// section 2.1.
+func WithPolicyTable(table []PolicyEntry) Option {
+	return func(r *Dialer) {
+		r.policyTable = normalizePolicyTable(table)
+	}
+}
+
+// WithQueryTypes sets the record types queried to resolve a host to addresses, TypeA,
+// TypeAAAA or both.
+//
+// IPv4-only or IPv6-only deployments can skip the query for the family they can't use.
+// Other record types are ignored, and without any A or AAAA left, the default is kept.
+// Dials on networks of a single family, like tcp4 or udp6, only query the matching type
+// regardless of this option.
+//
+// Default is [TypeA, TypeAAAA] if not specified.
+//
+// Example:
+//
+//	dialer := New(
+//	    WithResolvers("8.8.8.8", "1.1.1.1"),
+//	    WithQueryTypes(TypeA),
+//	)
+func WithQueryTypes(types ...RecordType) Option {
+	return func(r *Dialer) {
+		var qtypes []RecordType
+		for _, qtype := range types {
+			if (qtype == TypeA || qtype == TypeAAAA) && !slices.Contains(qtypes, qtype) {
+				qtypes = append(qtypes, qtype)
+			}
+		}
+		if len(qtypes) > 0 {
+			r.queryTypes = qtypes
+		}
+	}
+}
+
+// QuarantineConfig configures how long addresses that failed to connect are avoided.
+type QuarantineConfig struct {
+	// BaseDelay is how long an address is quarantined after its first failed dial. Every
+	// further failure in a row doubles it. Defaults to 5 seconds.
+	BaseDelay time.Duration
+
+	// MaxDelay caps the quarantine of an address that keeps failing. An address that
+	// doesn't fail again for this long after its quarantine ended starts over at
+	// BaseDelay. Defaults to 5 minutes.
+	MaxDelay time.Duration
+}
+
+// WithAddressQuarantine makes DialContext keep track of the addresses it fails to connect
+// to, and avoid them for a while, so a dead address behind a name doesn't cost every dial
+// a connect timeout.
+//
+// A failed dial quarantines the address for BaseDelay, backing off exponentially up to
+// MaxDelay while it keeps failing, and a successful dial releases it right away.
+// Quarantined addresses are only dialed once all other addresses of the host failed, or
+// if the host has no other addresses. Dials that fail because their context was canceled
+// or ran out don't count. An attempt that's still connecting when another address wins
+// the race, after its full connection attempt delay, counts as failed, that's how a
+// blackholed address shows. QuarantinedAddrs lists the addresses currently quarantined.
+//
+// Example:
+//
+//	dialer := New(
+//	    WithResolvers("8.8.8.8", "1.1.1.1"),
+//	    WithAddressQuarantine(QuarantineConfig{BaseDelay: 10 * time.Second}),
+//	)
+func WithAddressQuarantine(cfg QuarantineConfig) Option {
+	return func(r *Dialer) {
+		if cfg.BaseDelay <= 0 {
+			cfg.BaseDelay = 5 * time.Second
+		}
+		if cfg.MaxDelay <= 0 {
+			cfg.MaxDelay = 5 * time.Minute
+		}
+		cfg.MaxDelay = max(cfg.MaxDelay, cfg.BaseDelay)
+		r.quarantine = newAddrQuarantine(cfg)
+	}
+}
+
+// PrefetchConfig configures refreshing popular cache entries before they expire.
+type PrefetchConfig struct {
+	// Threshold is the fraction of an entry's TTL after which a cache hit triggers a
+	// background refresh, e.g. 0.9 refreshes an entry with a 60s TTL when a hit comes in
+	// during its last 6 seconds. Defaults to 0.9.
+	Threshold float64
+
+	// MinHits is the number of cache hits an entry needs before it's prefetched, so only
+	// hot hosts are kept warm and rarely used ones simply expire. Defaults to 2.
+	MinHits int
+
+	// Workers bounds the number of prefetches running at once. Prefetches requested while
+	// all workers are busy and the queue is full are dropped, the entry then expires and
+	// gets resolved on the next lookup like without prefetching. Defaults to 4.
+	Workers int
+}
+
+// WithPrefetch refreshes popular cache entries in the background before they expire, so
+// hot hosts never pay the full resolver latency on a cache miss.
+//
+// A hit on an entry that has been used at least MinHits times and is past Threshold of
+// its TTL queues a refresh, which a bounded pool of workers picks up. Callers are served
+// the cached answer right away either way. Prefetching only has an effect together with
+// WithCache, and its workers are stopped by Close.
+//
+// Example:
+//
+//	dialer := New(
+//	    WithResolvers("8.8.8.8", "1.1.1.1"),
+//	    WithCache(1000, 1*time.Second, 5*time.Minute),
+//	    WithPrefetch(PrefetchConfig{Threshold: 0.8}),
+//	)
+//	defer dialer.Close()
+func WithPrefetch(cfg PrefetchConfig) Option {
+	return func(r *Dialer) {
+		if cfg.Threshold <= 0 || cfg.Threshold >= 1 {
+			cfg.Threshold = 0.9
+		}
+		if cfg.MinHits <= 0 {
+			cfg.MinHits = 2
+		}
+		if cfg.Workers <= 0 {
+			cfg.Workers = 4
+		}
+		r.cacheConfig.prefetch = cfg
 	}
 }
@@ -0,0 +1,408 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// errConnClosed is returned when the pipelined connection a query was sent on goes
+// away before the response arrives, e.g. because the server closed an idle connection.
+var errConnClosed = errors.New("connection closed")
+
+// defaultMaxInFlight bounds how many queries may wait for a response on a single connection
+// or socket. It keeps one slow resolver from accumulating an unbounded number of pending
+// queries, and for UDP keeps the share of the 16-bit ID space in use on a socket small, so
+// a blind spoofing attempt is unlikely to hit an ID we're actually waiting for.
+const defaultMaxInFlight = 128
+
+// defaultIdleTimeout is how long a pipelined connection may sit without traffic before
+// we close it. Most DNS servers drop idle TCP connections after a few seconds anyway
+// (RFC 7766 recommends servers use a timeout of at least a few seconds), so there's no
+// point in holding on to them much longer than that.
+const defaultIdleTimeout = 10 * time.Second
+
+// pipelineConn multiplexes concurrent DNS queries over a single connection.
+//
+// RFC 7766 allows clients to send multiple queries over one TCP connection without
+// waiting for responses, and servers may answer them out of order. Responses are
+// matched back to their queries by message ID through a reader goroutine that owns
+// the read side of the connection. The same works for a connected UDP socket, where
+// every datagram is a complete message instead of a length-prefixed frame.
+type pipelineConn struct {
+	// conn wraps the underlying connection for DNS wire protocol handling (length-prefixed framing for streams)
+	conn *dns.Conn
+
+	// idleTimeout is the read deadline we keep pushing forward while the connection is in use
+	idleTimeout time.Duration
+
+	// packet is set for datagram sockets, where every read is a message on its own
+	packet bool
+
+	// writeMu serializes writes so two queries never interleave their bytes on the wire
+	writeMu sync.Mutex
+
+	// mu protects pending and err
+	mu sync.Mutex
+
+	// pending maps in-flight message IDs to the query waiting for a response
+	pending map[uint16]*pendingQuery
+
+	// err is set once the connection is unusable, all future queries fail with it
+	err error
+
+	// done is closed when the reader goroutine exits
+	done chan struct{}
+
+	// discarded counts responses that didn't match any query in flight, shared across the pool
+	discarded *atomic.Uint64
+}
+
+// pendingQuery is a query waiting for its response on a pipelined connection.
+type pendingQuery struct {
+	// query is what we sent, used to validate the response
+	query *dns.Msg
+
+	// ch receives the response, buffered so the reader never blocks on a waiter that gave up
+	ch chan *dns.Msg
+}
+
+func newPipelineConn(conn net.Conn, idleTimeout time.Duration, discarded *atomic.Uint64) *pipelineConn {
+	c := &pipelineConn{
+		// UDPSize is the read buffer for datagrams, ignored for streams. We size it for EDNS0
+		// responses, anything bigger would have to come back truncated anyway.
+		conn:        &dns.Conn{Conn: conn, UDPSize: dns.DefaultMsgSize},
+		idleTimeout: idleTimeout,
+		packet:      isPacketConn(conn),
+		pending:     make(map[uint16]*pendingQuery),
+		done:        make(chan struct{}),
+		discarded:   discarded,
+	}
+	go c.readLoop()
+	return c
+}
+
+// exchange sends msg over the connection and waits for the matching response.
+//
+// The message ID is overwritten with one that is unique among the queries currently
+// in flight on this connection, so callers don't need to coordinate IDs.
+func (c *pipelineConn) exchange(ctx context.Context, msg *dns.Msg, deadline time.Time) (*dns.Msg, error) {
+	pq := &pendingQuery{query: msg, ch: make(chan *dns.Msg, 1)}
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	// Pick a random ID that isn't already in flight. With at most a few hundred queries
+	// pending out of 65536 IDs, collisions are rare and this loop almost never repeats.
+	id := dns.Id()
+	for _, taken := c.pending[id]; taken; _, taken = c.pending[id] {
+		id = dns.Id()
+	}
+	msg.Id = id
+	c.pending[id] = pq
+	c.mu.Unlock()
+
+	// Whatever happens below, make sure we don't leave a stale entry behind that a late
+	// response could be delivered to.
+	defer c.forget(id, pq)
+
+	c.writeMu.Lock()
+	_ = c.conn.SetWriteDeadline(deadline)
+	err := c.conn.WriteMsg(msg)
+	c.writeMu.Unlock()
+	if err != nil {
+		// A failed or partial write leaves the stream in an unknown state, so nobody
+		// else can use this connection either.
+		err = fmt.Errorf("%w: %v", errConnClosed, err)
+		c.close(err)
+		return nil, err
+	}
+
+	// We just sent something, so give the server at least idleTimeout to respond before
+	// the reader gives up on the connection.
+	_ = c.conn.SetReadDeadline(time.Now().Add(c.idleTimeout))
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case response := <-pq.ch:
+		return response, nil
+	case <-c.done:
+		// The response may have been delivered right before the connection died
+		select {
+		case response := <-pq.ch:
+			return response, nil
+		default:
+		}
+		c.mu.Lock()
+		err := c.err
+		c.mu.Unlock()
+		return nil, err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timer.C:
+		return nil, fmt.Errorf("query failed: %w", os.ErrDeadlineExceeded)
+	}
+}
+
+// forget removes a query from the pending set. Once the reader has delivered a response,
+// the ID is free again and may already belong to a newer query, so we only remove the
+// entry if it is still ours.
+func (c *pipelineConn) forget(id uint16, pq *pendingQuery) {
+	c.mu.Lock()
+	if c.pending[id] == pq {
+		delete(c.pending, id)
+	}
+	c.mu.Unlock()
+}
+
+// readLoop reads responses off the connection and hands them to whoever is waiting for
+// them. It runs until the connection fails or stays idle for longer than idleTimeout.
+func (c *pipelineConn) readLoop() {
+	defer close(c.done)
+
+	for {
+		_ = c.conn.SetReadDeadline(time.Now().Add(c.idleTimeout))
+
+		response, err := c.conn.ReadMsg()
+		if err != nil {
+			if response != nil {
+				// The frame was read but didn't unpack. The stream is still in sync, so
+				// just drop this message and keep going, its waiter will time out.
+				c.discarded.Add(1)
+				continue
+			}
+			if c.packet && !isTimeout(err) && !errors.Is(err, net.ErrClosed) {
+				// A datagram that's too short or otherwise unreadable, or an ICMP error for
+				// an earlier packet. The next datagram is read on its own, so this one
+				// doesn't affect the other queries on the socket. Closing here would let
+				// anyone able to send us a single junk packet fail all of them.
+				c.discarded.Add(1)
+				continue
+			}
+			c.close(fmt.Errorf("%w: %v", errConnClosed, err))
+			return
+		}
+
+		// Only hand the response over if it answers the question we asked with that ID.
+		// Responses for queries we no longer wait for (e.g. they timed out) are dropped.
+		c.mu.Lock()
+		pq, ok := c.pending[response.Id]
+		if ok && responseMatches(pq.query, response) {
+			delete(c.pending, response.Id)
+		} else {
+			ok = false
+		}
+		c.mu.Unlock()
+
+		if !ok {
+			c.discarded.Add(1)
+			continue
+		}
+		pq.ch <- response
+	}
+}
+
+// isPacketConn reports whether conn is a datagram socket, like a connected UDP socket.
+func isPacketConn(conn net.Conn) bool {
+	_, ok := conn.(net.PacketConn)
+	return ok
+}
+
+// close marks the connection as unusable and closes the underlying socket. Queries in
+// flight are woken up through the done channel once the reader goroutine exits.
+func (c *pipelineConn) close(err error) {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.mu.Unlock()
+	_ = c.conn.Close()
+}
+
+// inFlight returns the number of queries currently waiting for a response.
+func (c *pipelineConn) inFlight() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
+}
+
+// closed reports whether the connection can no longer be used.
+func (c *pipelineConn) closed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err != nil
+}
+
+// pipelinePool manages a small set of pipelined connections to a single DNS resolver.
+//
+// Connections are shared rather than handed out one per query: many queries are in
+// flight on the same connection at once. The pool only dials a new connection when every
+// existing one is busy, up to 'size' connections. The total number of queries in flight
+// is bounded by 'size' times maxInFlight, callers beyond that wait for a slot to free up.
+//
+// Concurrency: The pool is safe for concurrent access.
+type pipelinePool struct {
+	// dial opens a new connection to the resolver, e.g. a connected UDP socket or plain TCP
+	dial func(ctx context.Context) (net.Conn, error)
+
+	// size is the maximum number of connections we'll keep open
+	size int
+
+	// idleTimeout is passed to every connection we create
+	idleTimeout time.Duration
+
+	// slots is a semaphore bounding the number of queries in flight across all connections
+	slots chan struct{}
+
+	// mu protects conns and closed
+	mu sync.Mutex
+
+	// conns holds the currently open connections
+	conns []*pipelineConn
+
+	// closed is set to true when pool is closed, prevents new connections from being created
+	closed bool
+
+	// discarded counts responses that didn't match any query in flight, across all connections
+	discarded atomic.Uint64
+}
+
+func newPipelinePool(dial func(ctx context.Context) (net.Conn, error), timeout time.Duration, size int) *pipelinePool {
+	if size <= 0 {
+		size = 4
+	}
+
+	// Make sure we never close a connection on a query that is still allowed to wait
+	idleTimeout := defaultIdleTimeout
+	if timeout > idleTimeout {
+		idleTimeout = timeout
+	}
+
+	return &pipelinePool{
+		dial:        dial,
+		size:        size,
+		idleTimeout: idleTimeout,
+		slots:       make(chan struct{}, size*defaultMaxInFlight),
+	}
+}
+
+// get returns the least busy open connection, dialing a new one if all connections
+// have queries in flight and the pool has room for another. The returned bool reports
+// whether the connection was freshly dialed.
+func (p *pipelinePool) get(ctx context.Context) (*pipelineConn, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil, false, net.ErrClosed
+	}
+
+	// Drop connections the server closed or that failed, and find the least busy one
+	var best *pipelineConn
+	bestLoad := 0
+	live := p.conns[:0]
+	for _, c := range p.conns {
+		if c.closed() {
+			continue
+		}
+		live = append(live, c)
+		if load := c.inFlight(); best == nil || load < bestLoad {
+			best, bestLoad = c, load
+		}
+	}
+	p.conns = live
+
+	// An idle connection is always the best choice. Otherwise, spread load across up to
+	// 'size' connections before we start stacking queries on busy ones.
+	if best != nil && (bestLoad == 0 || len(p.conns) >= p.size) {
+		return best, false, nil
+	}
+
+	// We dial while holding the lock. Concurrent callers would have to wait for this
+	// connection anyway, and it keeps a burst of queries from opening 'size' connections
+	// at the same time.
+	conn, err := p.dial(ctx)
+	if err != nil {
+		if best != nil {
+			// Still have a working connection, pipelining on it beats failing the query
+			return best, false, nil
+		}
+		return nil, false, err
+	}
+
+	c := newPipelineConn(conn, p.idleTimeout, &p.discarded)
+	p.conns = append(p.conns, c)
+	return c, true, nil
+}
+
+// exchange sends msg over one of the pooled connections and returns the response.
+func (p *pipelinePool) exchange(ctx context.Context, msg *dns.Msg, deadline time.Time) (*dns.Msg, error) {
+	// Wait for a free slot if the pool is saturated, rather than piling even more queries
+	// onto a resolver that is already slow to answer.
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		select {
+		case p.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+			return nil, fmt.Errorf("query failed: %w", os.ErrDeadlineExceeded)
+		}
+	}
+	defer func() { <-p.slots }()
+
+	// Servers are free to close idle connections at any time, and we only find out once we
+	// try to use one. If a reused connection turns out to be gone, retry on another one
+	// instead of failing the query. Every retry drops a dead connection from the pool, so
+	// we'll end up on a freshly dialed connection eventually.
+	for {
+		conn, fresh, err := p.get(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		response, err := conn.exchange(ctx, msg, deadline)
+		if err != nil && errors.Is(err, errConnClosed) && !fresh {
+			continue
+		}
+		return response, err
+	}
+}
+
+// Close shuts down the pool and closes all of its connections. Queries in flight fail
+// with errConnClosed.
+func (p *pipelinePool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil
+	}
+
+	p.closed = true
+	for _, c := range p.conns {
+		c.close(fmt.Errorf("%w: pool closed", errConnClosed))
+	}
+	p.conns = nil
+
+	return nil
+}
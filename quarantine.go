@@ -0,0 +1,208 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+)
+
+// QuarantinedAddr describes an address DialContext currently avoids because connecting to
+// it failed.
+type QuarantinedAddr struct {
+	Addr netip.Addr
+
+	// Failures is the number of dials to the address that failed in a row
+	Failures int
+
+	// Until is when the quarantine ends, and the address is dialed like any other again
+	Until time.Time
+
+	// Err is the error of the last failed dial
+	Err error
+}
+
+// quarantineEntry tracks the failed dials to a single address.
+type quarantineEntry struct {
+	failures int
+	until    time.Time
+	err      error
+}
+
+// addrQuarantine tracks dial failures per address, and keeps addresses that keep failing
+// out of the way of dials for a while, backing off exponentially. A nil addrQuarantine
+// tracks nothing, which is what a Dialer without WithAddressQuarantine has.
+type addrQuarantine struct {
+	baseDelay time.Duration
+	maxDelay  time.Duration
+
+	// now returns the current time, replaced in tests
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[netip.Addr]*quarantineEntry
+}
+
+func newAddrQuarantine(cfg QuarantineConfig) *addrQuarantine {
+	return &addrQuarantine{
+		baseDelay: cfg.BaseDelay,
+		maxDelay:  cfg.MaxDelay,
+		now:       time.Now,
+		entries:   make(map[netip.Addr]*quarantineEntry),
+	}
+}
+
+// ipAddr converts ip to the netip.Addr addresses are tracked by.
+func ipAddr(ip net.IP) netip.Addr {
+	addr, _ := netip.AddrFromSlice(ip)
+	return addr.Unmap()
+}
+
+// failed records a failed dial to ip and quarantines it, for baseDelay after the first
+// failure, doubling with every failure in a row up to maxDelay.
+func (q *addrQuarantine) failed(ip net.IP, err error) {
+	if q == nil {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	q.prune(now)
+
+	addr := ipAddr(ip)
+	entry, ok := q.entries[addr]
+	if !ok {
+		entry = &quarantineEntry{}
+		q.entries[addr] = entry
+	}
+	entry.failures++
+	entry.err = err
+
+	backoff := q.baseDelay
+	for i := 1; i < entry.failures && backoff < q.maxDelay; i++ {
+		backoff *= 2
+	}
+	entry.until = now.Add(min(backoff, q.maxDelay))
+}
+
+// succeeded records a successful dial to ip, which ends its quarantine and resets its
+// failures.
+func (q *addrQuarantine) succeeded(ip net.IP) {
+	if q == nil {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.entries, ipAddr(ip))
+}
+
+// prune forgets addresses whose quarantine ended more than maxDelay ago without failing
+// again, so an address that recovered starts over at baseDelay, and the map doesn't keep
+// every address that ever failed. Must be called with mu held.
+func (q *addrQuarantine) prune(now time.Time) {
+	for addr, entry := range q.entries {
+		if now.Sub(entry.until) > q.maxDelay {
+			delete(q.entries, addr)
+		}
+	}
+}
+
+// partition splits ips into the addresses to dial first and the quarantined ones, keeping
+// the order of the former. Quarantined addresses are ordered by the end of their
+// quarantine, the one closest to being released first.
+func (q *addrQuarantine) partition(ips []net.IP) (healthy, quarantined []net.IP) {
+	if q == nil {
+		return ips, nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	var held []quarantineHold
+	for _, ip := range ips {
+		if entry, ok := q.entries[ipAddr(ip)]; ok && now.Before(entry.until) {
+			held = append(held, quarantineHold{ip: ip, until: entry.until})
+			continue
+		}
+		healthy = append(healthy, ip)
+	}
+
+	sort.SliceStable(held, func(i, j int) bool {
+		return held[i].until.Before(held[j].until)
+	})
+	for _, h := range held {
+		quarantined = append(quarantined, h.ip)
+	}
+	return healthy, quarantined
+}
+
+// quarantineHold is a quarantined address being ordered by partition.
+type quarantineHold struct {
+	ip    net.IP
+	until time.Time
+}
+
+// list returns the addresses currently in quarantine, sorted by address.
+func (q *addrQuarantine) list() []QuarantinedAddr {
+	if q == nil {
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	var addrs []QuarantinedAddr
+	for addr, entry := range q.entries {
+		if now.Before(entry.until) {
+			addrs = append(addrs, QuarantinedAddr{Addr: addr, Failures: entry.failures, Until: entry.until, Err: entry.err})
+		}
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return addrs[i].Addr.Less(addrs[j].Addr)
+	})
+	return addrs
+}
+
+// QuarantinedAddrs returns the addresses DialContext currently avoids because dialing
+// them failed, see WithAddressQuarantine. It returns nil if quarantining is disabled.
+func (r *Dialer) QuarantinedAddrs() []QuarantinedAddr {
+	return r.quarantine.list()
+}
+
+// dialAddrs dials ips, leaving quarantined addresses for last. They're only dialed once
+// every other address failed, or if there's nothing else to dial at all. If pending is
+// set, the addresses of the rest of the answer join the race once they come in.
+func (r *Dialer) dialAddrs(ctx context.Context, network, host, port string, ips []net.IP, pending *pendingAnswer) (net.Conn, error) {
+	healthy, quarantined := r.quarantine.partition(ips)
+	if len(healthy) == 0 && pending == nil {
+		r.logger.Debug("all addresses quarantined, dialing them anyway",
+			Field{"host", host},
+			Field{"quarantined", len(quarantined)})
+		return r.dialIPs(ctx, network, host, port, quarantined, nil)
+	}
+
+	conn, err := r.dialIPs(ctx, network, host, port, healthy, pending)
+	if pending != nil {
+		quarantined = append(quarantined, pending.quarantined...)
+	}
+	if err == nil || len(quarantined) == 0 || ctx.Err() != nil {
+		return conn, err
+	}
+
+	r.logger.Debug("all other addresses failed, dialing quarantined addresses",
+		Field{"host", host},
+		Field{"quarantined", len(quarantined)})
+	return r.dialIPs(ctx, network, host, port, quarantined, nil)
+}
@@ -0,0 +1,219 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddrQuarantine_Backoff(t *testing.T) {
+	now := time.Now()
+	q := newAddrQuarantine(QuarantineConfig{BaseDelay: time.Second, MaxDelay: 5 * time.Second})
+	q.now = func() time.Time { return now }
+
+	ip := net.ParseIP("192.0.2.1")
+	refused := errors.New("connection refused")
+	for _, backoff := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		q.failed(ip, refused)
+		require.Len(t, q.list(), 1)
+		assert.Equal(t, now.Add(backoff), q.list()[0].Until)
+	}
+	assert.Equal(t, QuarantinedAddr{
+		Addr:     netip.MustParseAddr("192.0.2.1"),
+		Failures: 5,
+		Until:    now.Add(5 * time.Second),
+		Err:      refused,
+	}, q.list()[0])
+
+	// Once the quarantine is over, the address is dialed like any other again
+	now = now.Add(6 * time.Second)
+	assert.Empty(t, q.list())
+	healthy, quarantined := q.partition([]net.IP{ip})
+	assert.Equal(t, []net.IP{ip}, healthy)
+	assert.Empty(t, quarantined)
+
+	// Failing again right away continues the backoff, but after a while without failures
+	// it starts over
+	q.failed(ip, refused)
+	assert.Equal(t, 6, q.list()[0].Failures)
+	now = now.Add(time.Minute)
+	q.failed(ip, refused)
+	assert.Equal(t, 1, q.list()[0].Failures)
+
+	q.succeeded(ip)
+	assert.Empty(t, q.list())
+}
+
+func TestAddrQuarantine_Partition(t *testing.T) {
+	q := newAddrQuarantine(QuarantineConfig{BaseDelay: time.Second, MaxDelay: time.Minute})
+	ips := parseIPs("192.0.2.1", "192.0.2.2", "2001:db8::1", "192.0.2.3")
+
+	q.failed(ips[0], errors.New("connection refused"))
+	q.failed(ips[0], errors.New("connection refused"))
+	q.failed(ips[2], errors.New("connection refused"))
+
+	healthy, quarantined := q.partition(ips)
+	assert.Equal(t, parseIPs("192.0.2.2", "192.0.2.3"), healthy)
+
+	// The address released soonest comes first
+	assert.Equal(t, parseIPs("2001:db8::1", "192.0.2.1"), quarantined)
+}
+
+func TestDialer_DialContext_Quarantine(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	dialer := New(
+		WithCustomResolvers(&mockResolver{name: "dns", err: errors.New("must not be queried")}),
+		WithStaticHosts(map[string][]netip.Addr{
+			"api.example.com": {netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("127.0.0.1")},
+			"db.example.com":  {netip.MustParseAddr("192.0.2.1")},
+		}),
+		WithAddressPreference(PreferIPv4),
+		WithConnectionAttemptDelay(-1),
+		WithAddressQuarantine(QuarantineConfig{}),
+	)
+	defer dialer.Close()
+
+	var attempts []string
+	refused := errors.New("connection refused")
+	dialer.dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, _ := net.SplitHostPort(addr)
+		attempts = append(attempts, host)
+		if host == "192.0.2.1" {
+			return nil, refused
+		}
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	}
+	dial := func(host string) error {
+		attempts = nil
+		conn, err := dialer.DialContext(context.Background(), "tcp", net.JoinHostPort(host, port))
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+
+	require.NoError(t, dial("api.example.com"))
+	assert.Equal(t, []string{"192.0.2.1", "127.0.0.1"}, attempts)
+
+	quarantined := dialer.QuarantinedAddrs()
+	require.Len(t, quarantined, 1)
+	assert.Equal(t, netip.MustParseAddr("192.0.2.1"), quarantined[0].Addr)
+	assert.Equal(t, refused, quarantined[0].Err)
+
+	// The failing address is skipped from now on
+	require.NoError(t, dial("api.example.com"))
+	assert.Equal(t, []string{"127.0.0.1"}, attempts)
+
+	// Unless there's nothing else left to dial
+	assert.ErrorIs(t, dial("db.example.com"), refused)
+	assert.Equal(t, []string{"192.0.2.1"}, attempts)
+	assert.Equal(t, 2, dialer.QuarantinedAddrs()[0].Failures)
+}
+
+func TestDialer_DialContext_QuarantinesBlackholedAddress(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	dialer := New(
+		WithCustomResolvers(&mockResolver{name: "dns", err: errors.New("must not be queried")}),
+		WithStaticHosts(map[string][]netip.Addr{
+			"api.example.com": {netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("127.0.0.1")},
+		}),
+		WithAddressPreference(PreferIPv4),
+		WithConnectionAttemptDelay(20*time.Millisecond),
+		WithAddressQuarantine(QuarantineConfig{}),
+	)
+	defer dialer.Close()
+	blackhole := &blackholeDialer{}
+	var mu sync.Mutex
+	var attempts []string
+	dialer.dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		mu.Lock()
+		attempts = append(attempts, addr)
+		mu.Unlock()
+		return blackhole.dial(ctx, network, addr)
+	}
+
+	// The blackholed address hangs until it loses the race, which is all it ever does
+	conn, err := dialer.DialContext(context.Background(), "tcp", net.JoinHostPort("api.example.com", port))
+	require.NoError(t, err)
+	conn.Close()
+
+	quarantined := dialer.QuarantinedAddrs()
+	require.Len(t, quarantined, 1)
+	assert.Equal(t, netip.MustParseAddr("192.0.2.1"), quarantined[0].Addr)
+	assert.ErrorIs(t, quarantined[0].Err, errSlowAttempt)
+
+	// So the next dial doesn't wait for it again
+	mu.Lock()
+	attempts = nil
+	mu.Unlock()
+	conn, err = dialer.DialContext(context.Background(), "tcp", net.JoinHostPort("api.example.com", port))
+	require.NoError(t, err)
+	conn.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{net.JoinHostPort("127.0.0.1", port)}, attempts)
+}
+
+func TestDialer_DialContext_NoAttemptDelayDoesNotQuarantine(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	dialer := New(
+		WithCustomResolvers(&mockResolver{name: "dns", err: errors.New("must not be queried")}),
+		WithStaticHosts(map[string][]netip.Addr{
+			"api.example.com": {netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.2")},
+		}),
+		WithAddressPreference(PreferIPv4),
+		WithConnectionAttemptDelay(0),
+		WithAddressQuarantine(QuarantineConfig{}),
+	)
+	defer dialer.Close()
+
+	// Both addresses are healthy, the first one just takes a little longer to connect
+	dialer.dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if host, _, _ := net.SplitHostPort(addr); host == "192.0.2.1" {
+			select {
+			case <-time.After(5 * time.Millisecond):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		return (&net.Dialer{}).DialContext(ctx, network, listener.Addr().String())
+	}
+
+	// All attempts start at once, so the one that loses never had a head start to use up
+	conn, err := dialer.DialContext(context.Background(), "tcp", net.JoinHostPort("api.example.com", port))
+	require.NoError(t, err)
+	conn.Close()
+
+	assert.Empty(t, dialer.QuarantinedAddrs())
+}
+
+func TestDialer_QuarantinedAddrs_Disabled(t *testing.T) {
+	dialer := New()
+	defer dialer.Close()
+	assert.Nil(t, dialer.QuarantinedAddrs())
+}
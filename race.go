@@ -10,7 +10,7 @@ import (
 	"time"
 )
 
-func (s Race) ResolveType(ctx context.Context, host string, qtype RecordType, resolvers []resolver, logger Logger) ([]Record, error) {
+func (s Race) ResolveType(ctx context.Context, host string, qtype RecordType, resolvers []Resolver, logger Logger) ([]Record, error) {
 	// Create a cancellable context so we can stop in-flight queries once we get
 	// a successful response. This prevents unnecessary network traffic and reduces
 	// load on DNS resolvers.
@@ -33,7 +33,7 @@ func (s Race) ResolveType(ctx context.Context, host string, qtype RecordType, re
 	// at the cost of increased network traffic, all servers get queried even though
 	// we only use one response.
 	for _, res := range resolvers {
-		go func(r resolver) {
+		go func(r Resolver) {
 			start := time.Now()
 			records, err := r.ResolveType(ctx, host, qtype)
 			results <- result{
@@ -48,7 +48,7 @@ func (s Race) ResolveType(ctx context.Context, host string, qtype RecordType, re
 	// Return the first successful response. We have to wait for all resolvers to
 	// either succeed or fail before giving up, since early failures from fast-but-broken
 	// resolvers shouldn't prevent us from getting results from slower-but-working ones.
-	var lastErr error
+	var errs []*ResolverError
 	for i := 0; i < len(resolvers); i++ {
 		r := <-results
 		if r.err == nil {
@@ -62,11 +62,10 @@ func (s Race) ResolveType(ctx context.Context, host string, qtype RecordType, re
 			cancel()
 			return r.records, nil
 		}
-		lastErr = r.err
+		errs = append(errs, &ResolverError{Resolver: r.resolver, Err: r.err})
 	}
 
-	// All resolvers failed. Return the last error we encountered. In a production
-	// system, you might want to aggregate all errors to help diagnose whether one
-	// resolver is down vs. the domain doesn't exist.
-	return nil, lastErr
+	// All resolvers failed. Return every resolver's error, so the caller can tell
+	// whether one resolver is down or the domain doesn't exist.
+	return nil, &AggregateError{Host: host, Type: qtype, Errors: errs}
 }
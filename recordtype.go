@@ -7,6 +7,8 @@ package dnsdialer
 
 import (
 	"fmt"
+	"net/netip"
+	"strings"
 
 	"github.com/miekg/dns"
 )
@@ -42,12 +44,155 @@ func (rt RecordType) String() string {
 
 // Record represents a DNS record with its value
 type Record struct {
-	Type  RecordType
+	// Name is the owner name of the record, fully qualified (e.g., "example.com."). When a
+	// CNAME chain was followed, it's the canonical name the chain led to.
+	Name string
+
+	Type RecordType
+
+	// Value is the record data formatted as a string, e.g. "10 mail.example.com." for an
+	// MX record. It's what strategies compare records by.
 	Value string
-	TTL   uint32
+
+	TTL uint32
+
+	// Data holds the record data with full fidelity, e.g. an MXData with the preference
+	// and host as separate fields, or a TXTData with each string of a TXT record kept
+	// apart. Use a type switch to get at it. It's nil for record types without their own
+	// data type, and may be nil for records from custom resolvers that only set Value.
+	Data RecordData
+}
+
+// RecordData is the typed data of a record, one of AData, AAAAData, CNAMEData, MXData,
+// NSData, TXTData, SOAData, PTRData or SRVData.
+type RecordData interface {
+	// RecordType returns the type of record the data belongs to
+	RecordType() RecordType
+}
+
+// AData is the data of an A record.
+type AData struct {
+	Addr netip.Addr
+}
+
+// AAAAData is the data of an AAAA record.
+type AAAAData struct {
+	Addr netip.Addr
+}
+
+// CNAMEData is the data of a CNAME record.
+type CNAMEData struct {
+	// Target is the canonical name, fully qualified (e.g., "www.example.com.")
+	Target string
+}
+
+// MXData is the data of an MX record.
+type MXData struct {
+	Preference uint16
+
+	// Host is the mail server, fully qualified (e.g., "mail.example.com.")
+	Host string
 }
 
+// NSData is the data of an NS record.
+type NSData struct {
+	// Host is the name server, fully qualified (e.g., "ns1.example.com.")
+	Host string
+}
+
+// TXTData is the data of a TXT record. A TXT record consists of one or more strings of
+// at most 255 bytes each, longer values (e.g. DKIM keys) are split across several of them,
+// so it's up to the caller whether to join them and how.
+type TXTData []string
+
+// SOAData is the data of an SOA record.
+type SOAData struct {
+	NS      string
+	Mbox    string
+	Serial  uint32
+	Refresh uint32
+	Retry   uint32
+	Expire  uint32
+	MinTTL  uint32
+}
+
+// PTRData is the data of a PTR record.
+type PTRData struct {
+	// Target is the name the address points to, fully qualified (e.g., "example.com.")
+	Target string
+}
+
+// SRVData is the data of an SRV record.
+type SRVData struct {
+	Priority uint16
+	Weight   uint16
+	Port     uint16
+
+	// Target is the host providing the service, fully qualified (e.g., "sip.example.com.")
+	Target string
+}
+
+func (AData) RecordType() RecordType     { return TypeA }
+func (AAAAData) RecordType() RecordType  { return TypeAAAA }
+func (CNAMEData) RecordType() RecordType { return TypeCNAME }
+func (MXData) RecordType() RecordType    { return TypeMX }
+func (NSData) RecordType() RecordType    { return TypeNS }
+func (TXTData) RecordType() RecordType   { return TypeTXT }
+func (SOAData) RecordType() RecordType   { return TypeSOA }
+func (PTRData) RecordType() RecordType   { return TypePTR }
+func (SRVData) RecordType() RecordType   { return TypeSRV }
+
 // String returns a string representation of the record
 func (r Record) String() string {
 	return fmt.Sprintf("%s: %s (TTL: %d)", r.Type.String(), r.Value, r.TTL)
 }
+
+// data returns the typed data of the record. Records from custom resolvers may only have a
+// Value, in that case the data is parsed from it as far as the Value format allows, and nil
+// is returned if it can't be.
+func (r Record) data() RecordData {
+	if r.Data != nil {
+		return r.Data
+	}
+
+	switch r.Type {
+	case TypeA, TypeAAAA:
+		addr, err := netip.ParseAddr(r.Value)
+		if err != nil {
+			return nil
+		}
+		if r.Type == TypeA {
+			return AData{Addr: addr.Unmap()}
+		}
+		return AAAAData{Addr: addr}
+	case TypeCNAME:
+		return CNAMEData{Target: r.Value}
+	case TypeNS:
+		return NSData{Host: r.Value}
+	case TypePTR:
+		return PTRData{Target: r.Value}
+	case TypeTXT:
+		// The boundaries between strings are lost in the Value, so it becomes a single one
+		return TXTData{strings.TrimSuffix(strings.TrimPrefix(r.Value, "["), "]")}
+	case TypeMX:
+		var mx MXData
+		if _, err := fmt.Sscanf(r.Value, "%d %s", &mx.Preference, &mx.Host); err != nil {
+			return nil
+		}
+		return mx
+	case TypeSRV:
+		var srv SRVData
+		if _, err := fmt.Sscanf(r.Value, "%d %d %d %s", &srv.Priority, &srv.Weight, &srv.Port, &srv.Target); err != nil {
+			return nil
+		}
+		return srv
+	case TypeSOA:
+		var soa SOAData
+		if _, err := fmt.Sscanf(r.Value, "%s %s %d %d %d %d %d",
+			&soa.NS, &soa.Mbox, &soa.Serial, &soa.Refresh, &soa.Retry, &soa.Expire, &soa.MinTTL); err != nil {
+			return nil
+		}
+		return soa
+	}
+	return nil
+}
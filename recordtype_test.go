@@ -0,0 +1,80 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseResponse_Data(t *testing.T) {
+	tests := []struct {
+		rr    string
+		value string
+		data  RecordData
+	}{
+		{
+			rr:    "example.com. 60 IN A 192.0.2.1",
+			value: "192.0.2.1",
+			data:  AData{Addr: netip.MustParseAddr("192.0.2.1")},
+		},
+		{
+			rr:    "example.com. 60 IN AAAA 2001:db8::1",
+			value: "2001:db8::1",
+			data:  AAAAData{Addr: netip.MustParseAddr("2001:db8::1")},
+		},
+		{
+			rr:    "example.com. 60 IN MX 10 mail.example.com.",
+			value: "10 mail.example.com.",
+			data:  MXData{Preference: 10, Host: "mail.example.com."},
+		},
+		{
+			// The string boundaries are lost in Value, but not in Data
+			rr:    `example.com. 60 IN TXT "v=DKIM1; p=abc" "def"`,
+			value: "[v=DKIM1; p=abc def]",
+			data:  TXTData{"v=DKIM1; p=abc", "def"},
+		},
+		{
+			rr:    "_sip._tcp.example.com. 60 IN SRV 10 5 5060 sip.example.com.",
+			value: "10 5 5060 sip.example.com.",
+			data:  SRVData{Priority: 10, Weight: 5, Port: 5060, Target: "sip.example.com."},
+		},
+		{
+			rr:    "example.com. 60 IN SOA ns.example.com. hostmaster.example.com. 1 7200 3600 1209600 300",
+			value: "ns.example.com. hostmaster.example.com. 1 7200 3600 1209600 300",
+			data: SOAData{NS: "ns.example.com.", Mbox: "hostmaster.example.com.",
+				Serial: 1, Refresh: 7200, Retry: 3600, Expire: 1209600, MinTTL: 300},
+		},
+	}
+
+	for _, tt := range tests {
+		rr, err := dns.NewRR(tt.rr)
+		require.NoError(t, err)
+
+		t.Run(RecordType(rr.Header().Rrtype).String(), func(t *testing.T) {
+			resp := new(dns.Msg)
+			resp.SetReply(newQuery(rr.Header().Name, RecordType(rr.Header().Rrtype)))
+			resp.Answer = append(resp.Answer, rr)
+
+			records, err := parseResponse(resp)
+			require.NoError(t, err)
+			require.Len(t, records, 1)
+			assert.Equal(t, tt.value, records[0].Value)
+			assert.Equal(t, tt.data, records[0].Data)
+			assert.Equal(t, records[0].Type, records[0].Data.RecordType())
+
+			// Records without Data, e.g. from custom resolvers, get it parsed from Value.
+			// Only TXT can't be recovered exactly.
+			if records[0].Type != TypeTXT {
+				assert.Equal(t, tt.data, Record{Type: records[0].Type, Value: tt.value}.data())
+			}
+		})
+	}
+}
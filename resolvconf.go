@@ -0,0 +1,279 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultResolvConfPath is where the system resolver configuration lives on Unix systems
+	defaultResolvConfPath = "/etc/resolv.conf"
+
+	// maxNameservers is the number of nameservers glibc uses, any beyond that are ignored
+	maxNameservers = 3
+
+	// retiredResolverGrace is how long resolvers replaced by a resolv.conf reload are kept
+	// open, so lookups that started with them can finish before they're closed
+	retiredResolverGrace = time.Minute
+)
+
+// resolvConf is the configuration read from a resolv.conf file.
+type resolvConf struct {
+	nameservers []string
+	search      []string
+	ndots       int
+	timeout     time.Duration
+	attempts    int
+	rotate      bool
+}
+
+// resolvConfSource tracks the resolv.conf file a Dialer was built from, for the watcher.
+type resolvConfSource struct {
+	// path of the file, empty if the Dialer wasn't built from one
+	path string
+
+	// data is the content the current resolvers were built from
+	data []byte
+
+	// interval is how often the watcher checks the file, zero disables it
+	interval time.Duration
+
+	// first is the index of the first resolver built from the file. Resolvers added by other
+	// options come before it and are kept as they are on reload.
+	first int
+}
+
+// parseResolvConf parses the content of a resolv.conf file, see resolv.conf(5).
+//
+// Like glibc, it uses the first three nameservers, and the last search or domain line
+// wins. Unknown directives and options are ignored, and values out of range are clamped
+// to what glibc accepts. Without any nameserver, the local host is used.
+func parseResolvConf(data []byte) *resolvConf {
+	conf := &resolvConf{
+		ndots:    defaultNdots,
+		timeout:  5 * time.Second,
+		attempts: 2,
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "nameserver":
+			if len(conf.nameservers) < maxNameservers && validNameserver(fields[1]) {
+				conf.nameservers = append(conf.nameservers, fields[1])
+			}
+		case "domain":
+			conf.search = []string{fields[1]}
+		case "search":
+			conf.search = append([]string(nil), fields[1:]...)
+		case "options":
+			for _, opt := range fields[1:] {
+				name, value, _ := strings.Cut(opt, ":")
+				n, err := strconv.Atoi(value)
+				switch {
+				case name == "rotate":
+					conf.rotate = true
+				case err != nil || n < 0:
+					// All other options we know take a number
+				case name == "ndots":
+					conf.ndots = min(n, maxNdots)
+				case name == "timeout":
+					conf.timeout = time.Duration(min(max(n, 1), 30)) * time.Second
+				case name == "attempts":
+					conf.attempts = min(max(n, 1), 5)
+				}
+			}
+		}
+	}
+
+	if len(conf.nameservers) == 0 {
+		conf.nameservers = []string{"127.0.0.1", "::1"}
+	}
+	return conf
+}
+
+// validNameserver reports whether addr is an IP address, optionally with a port. Ports
+// aren't part of resolv.conf, but they're handy for pointing at a local test server.
+func validNameserver(addr string) bool {
+	if _, err := netip.ParseAddr(addr); err == nil {
+		return true
+	}
+	_, err := netip.ParseAddrPort(addr)
+	return err == nil
+}
+
+// NewFromResolvConf creates a Dialer configured like the system resolver, from the
+// resolv.conf file at path, or /etc/resolv.conf if path is empty.
+//
+// The nameservers become UDP resolvers queried with the Fallback strategy, honoring the
+// attempts and rotate options. The search and domain lines, and the ndots and timeout
+// options, map to WithSearchDomains, WithNdots and WithTimeout.
+//
+// opts are applied after the settings from the file, so they can override them, e.g.
+// WithStrategy or WithTimeout. Resolvers added by opts are queried before the
+// nameservers from the file. To pick up changes to the file at runtime, pass
+// WithResolvConfWatch.
+//
+// Example:
+//
+//	dialer, err := NewFromResolvConf("",
+//	    WithCache(1000, 1*time.Second, 5*time.Minute),
+//	    WithResolvConfWatch(5*time.Second),
+//	)
+//	if err != nil {
+//	    return err
+//	}
+//	defer dialer.Close()
+func NewFromResolvConf(path string, opts ...Option) (*Dialer, error) {
+	if path == "" {
+		path = defaultResolvConfPath
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	conf := parseResolvConf(data)
+
+	options := []Option{
+		WithTimeout(conf.timeout),
+		WithSearchDomains(conf.search...),
+		WithNdots(conf.ndots),
+		WithStrategy(Fallback{Attempts: conf.attempts, Rotate: conf.rotate}),
+	}
+	options = append(options, opts...)
+
+	// The nameservers are added last, so the resolvers use the timeout and pool size as
+	// they are after all other options
+	options = append(options, func(r *Dialer) {
+		r.resolvConf.path = path
+		r.resolvConf.data = data
+		r.resolvConf.first = len(r.resolvers)
+		r.resolvers = append(r.resolvers, r.nameserverResolvers(conf)...)
+	})
+
+	return New(options...), nil
+}
+
+// WithResolvConfWatch makes a Dialer created by NewFromResolvConf check its resolv.conf
+// file for changes every interval, and switch to the new nameservers when it changes.
+//
+// Only the nameservers are reloaded, the other settings stay as they were when the Dialer
+// was created. Lookups already in progress finish with the previous resolvers, which are
+// closed a minute later. If the file can't be read, the current resolvers are kept. The
+// watcher is stopped by Close. This option has no effect on a Dialer created by New.
+func WithResolvConfWatch(interval time.Duration) Option {
+	return func(r *Dialer) {
+		if interval > 0 {
+			r.resolvConf.interval = interval
+		}
+	}
+}
+
+// nameserverResolvers creates a UDP resolver for each of the nameservers in conf.
+func (r *Dialer) nameserverResolvers(conf *resolvConf) []Resolver {
+	resolvers := make([]Resolver, 0, len(conf.nameservers))
+	for _, ns := range conf.nameservers {
+		resolvers = append(resolvers, newUDPResolver(ns, r.timeout, r.poolSize))
+	}
+	return resolvers
+}
+
+// retiredResolvers are resolvers replaced by a reload, waiting to be closed.
+type retiredResolvers struct {
+	resolvers []Resolver
+	at        time.Time
+}
+
+// watchResolvConf starts checking the resolv.conf file for changes in the background,
+// until the Dialer is closed.
+func (r *Dialer) watchResolvConf() {
+	r.background.Add(1)
+	go func() {
+		defer r.background.Done()
+
+		ticker := time.NewTicker(r.resolvConf.interval)
+		defer ticker.Stop()
+
+		var retired []retiredResolvers
+		defer func() {
+			// Nothing uses them anymore once the Dialer is closed
+			r.closeRetired(retired, time.Time{})
+		}()
+
+		last := r.resolvConf.data
+		for {
+			select {
+			case <-r.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			retired = r.closeRetired(retired, time.Now().Add(-retiredResolverGrace))
+
+			data, err := os.ReadFile(r.resolvConf.path)
+			if err != nil {
+				r.logger.Error("reading resolv.conf failed", err,
+					Field{"path", r.resolvConf.path})
+				continue
+			}
+			if bytes.Equal(data, last) {
+				continue
+			}
+			last = data
+
+			conf := parseResolvConf(data)
+			current, _ := r.activeResolvers()
+			resolvers := append([]Resolver(nil), current[:r.resolvConf.first]...)
+			resolvers = append(resolvers, r.nameserverResolvers(conf)...)
+
+			old := r.swapResolvers(resolvers)
+			retired = append(retired, retiredResolvers{resolvers: old[r.resolvConf.first:], at: time.Now()})
+
+			r.logger.Info("reloaded resolv.conf",
+				Field{"path", r.resolvConf.path},
+				Field{"nameservers", strings.Join(conf.nameservers, ",")})
+		}
+	}()
+}
+
+// closeRetired closes the retired resolvers replaced before cutoff, or all of them if
+// cutoff is zero, and returns the ones still waiting.
+func (r *Dialer) closeRetired(retired []retiredResolvers, cutoff time.Time) []retiredResolvers {
+	waiting := retired[:0]
+	for _, set := range retired {
+		if !cutoff.IsZero() && set.at.After(cutoff) {
+			waiting = append(waiting, set)
+			continue
+		}
+		for _, res := range set.resolvers {
+			if c, ok := res.(io.Closer); ok {
+				if err := c.Close(); err != nil {
+					r.logger.Error("closing retired resolver failed", err,
+						Field{"resolver", res.Name()})
+				}
+			}
+		}
+	}
+	return waiting
+}
@@ -0,0 +1,114 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseResolvConf(t *testing.T) {
+	conf := parseResolvConf([]byte(`
+# Generated by NetworkManager
+domain corp.example.com
+search default.svc.cluster.local svc.cluster.local ; the domain line is overridden
+nameserver 10.0.0.1
+nameserver fe80::1%eth0
+nameserver not-an-ip
+nameserver 10.0.0.2
+nameserver 10.0.0.3
+options ndots:5 timeout:60 attempts:0 rotate edns0 ndots:x
+`))
+
+	assert.Equal(t, []string{"10.0.0.1", "fe80::1%eth0", "10.0.0.2"}, conf.nameservers)
+	assert.Equal(t, []string{"default.svc.cluster.local", "svc.cluster.local"}, conf.search)
+	assert.Equal(t, 5, conf.ndots)
+	assert.Equal(t, 30*time.Second, conf.timeout)
+	assert.Equal(t, 1, conf.attempts)
+	assert.True(t, conf.rotate)
+
+	// glibc's defaults
+	conf = parseResolvConf(nil)
+	assert.Equal(t, &resolvConf{
+		nameservers: []string{"127.0.0.1", "::1"},
+		ndots:       1,
+		timeout:     5 * time.Second,
+		attempts:    2,
+	}, conf)
+}
+
+func TestNewFromResolvConf(t *testing.T) {
+	addr, _ := startTestZoneServer(t)
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	require.NoError(t, os.WriteFile(path, []byte("nameserver "+addr+"\nsearch example.com\noptions ndots:2 timeout:1 attempts:3 rotate\n"), 0o644))
+
+	dialer, err := NewFromResolvConf(path, WithCache(10, time.Second, time.Minute))
+	require.NoError(t, err)
+	defer dialer.Close()
+
+	assert.Equal(t, Fallback{Attempts: 3, Rotate: true}, dialer.strategy)
+	assert.Equal(t, time.Second, dialer.timeout)
+	assert.Equal(t, []string{"example.com"}, dialer.searchDomains)
+	assert.Equal(t, 2, dialer.ndots)
+
+	ips, err := dialer.LookupIP(context.Background(), "ip4", "www")
+	require.NoError(t, err)
+	assert.Equal(t, []net.IP{net.ParseIP("192.0.2.1")}, ips)
+
+	_, err = NewFromResolvConf(filepath.Join(t.TempDir(), "missing.conf"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestDialer_ResolvConfWatch(t *testing.T) {
+	server := func(ip string) string {
+		return startTestDNSServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+			resp := new(dns.Msg)
+			resp.SetReply(req)
+			if req.Question[0].Qtype == dns.TypeA {
+				resp.Answer = append(resp.Answer, &dns.A{
+					Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+					A:   net.ParseIP(ip),
+				})
+			}
+			_ = w.WriteMsg(resp)
+		})
+	}
+	first, second := server("192.0.2.1"), server("192.0.2.2")
+
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	require.NoError(t, os.WriteFile(path, []byte("nameserver "+first+"\n"), 0o644))
+
+	static := &mockResolver{name: "static", err: &NotFoundError{Host: "example.com"}}
+	dialer, err := NewFromResolvConf(path,
+		WithCustomResolvers(static),
+		WithResolvConfWatch(10*time.Millisecond),
+	)
+	require.NoError(t, err)
+	defer dialer.Close()
+
+	ips, err := dialer.LookupIP(context.Background(), "ip4", "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []net.IP{net.ParseIP("192.0.2.1")}, ips)
+
+	require.NoError(t, os.WriteFile(path, []byte("nameserver "+second+"\n"), 0o644))
+	assert.Eventually(t, func() bool {
+		ips, err := dialer.LookupIP(context.Background(), "ip4", "example.com")
+		return err == nil && ips[0].Equal(net.ParseIP("192.0.2.2"))
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// Resolvers that didn't come from the file are kept
+	resolvers, _ := dialer.activeResolvers()
+	require.Len(t, resolvers, 2)
+	assert.Equal(t, static, resolvers[0])
+}
@@ -8,15 +8,27 @@ package dnsdialer
 import (
 	"context"
 	"fmt"
+	"io"
 	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 )
 
-// resolver is the internal interface that all DNS resolver implementations must satisfy.
+// Resolver is the interface that all DNS resolver implementations must satisfy.
 //
 // This abstraction allows strategies to work with any resolver implementation
-// (UDP, TCP, DNS-over-HTTPS, etc.) without knowing the transport details.
-type resolver interface {
+// (UDP, TCP, DNS-over-HTTPS, etc.) without knowing the transport details. The
+// built-in transports are configured through options like WithResolvers, and
+// custom implementations, such as a resolver backed by a service registry or a
+// fake for tests, can be added with WithCustomResolvers.
+//
+// Implementations must be safe for concurrent use, strategies like Race query
+// all resolvers at the same time and the Dialer itself may be shared across
+// goroutines.
+type Resolver interface {
 	// ResolveType performs a DNS query for a specific record type.
 	// Returns records on success, or an error if the query fails.
 	ResolveType(ctx context.Context, host string, qtype RecordType) ([]Record, error)
@@ -33,7 +45,36 @@ type resolver interface {
 // approaches.
 type Dialer struct {
 	// resolvers is the list of DNS resolvers we'll query (e.g., UDP resolvers for 8.8.8.8, 1.1.1.1)
-	resolvers []resolver
+	resolvers []Resolver
+
+	// chasers wraps each of the resolvers to follow CNAME chains, it's what lookups hand to
+	// the strategy so it compares the records the chains lead to
+	chasers []Resolver
+
+	// resolversMu protects resolvers and chasers, which get replaced as a whole when the
+	// resolv.conf watcher reloads them. The slices themselves are never modified after New.
+	resolversMu sync.RWMutex
+
+	// resolvConf is the resolv.conf file the Dialer was built from, if any
+	resolvConf resolvConfSource
+
+	// staticHosts are addresses set with WithStaticHosts, keyed by normalized host name
+	staticHosts map[string][]netip.Addr
+
+	// hostsFile is the hosts file set with WithHostsFile, nil if none
+	hostsFile *hostsFile
+
+	// maxCNAMEDepth is the maximum number of CNAMEs followed for a single lookup
+	maxCNAMEDepth int
+
+	// searchDomains are appended to relative host names, like the search list in resolv.conf
+	searchDomains []string
+
+	// ndots is the number of dots a host needs to be tried as is before the search list
+	ndots int
+
+	// queryTypes are the record types queried to resolve a host to addresses
+	queryTypes []RecordType
 
 	// strategy determines how we coordinate queries (Race, Fallback, Consensus, Compare)
 	strategy Strategy
@@ -44,14 +85,73 @@ type Dialer struct {
 	// logger is the structured logging interface, no-op by default so zero overhead if you don't need it
 	logger Logger
 
-	// poolSize is the max connections to pool per resolver, defaults to 4
+	// poolSize is the max sockets or connections per resolver, defaults to 4
 	poolSize int
 
 	// dialer is reused for TCP/UDP connections to avoid allocating a new one each time
 	dialer *net.Dialer
 
+	// dial opens a connection to a single address, it's dialer.DialContext unless replaced
+	// in tests
+	dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// attemptDelay is how long a connection attempt gets before the next address is tried
+	// in parallel (Happy Eyeballs), negative to only try the next address once one fails
+	attemptDelay time.Duration
+
+	// addrPreference determines the order in which addresses are dialed
+	addrPreference AddressPreference
+
+	// policyTable is the RFC 6724 policy table, normalized, nil for the default one
+	policyTable []PolicyEntry
+
+	// sourceAddr finds the source address for a destination, routeSourceAddr cached for a
+	// few seconds unless replaced in tests
+	sourceAddr func(dst netip.Addr) (netip.Addr, bool)
+
+	// quarantine tracks addresses that failed to connect, nil unless enabled with
+	// WithAddressQuarantine
+	quarantine *addrQuarantine
+
 	// cache stores DNS lookup results with TTL-based expiration, disabled by default
 	cache *dnsCache
+
+	// cacheConfig collects the cache settings from options, the cache is built from it in New
+	cacheConfig cacheConfig
+
+	// staleRecheck is how long we keep serving a stale answer without asking the resolvers
+	// after refreshing it failed, and how often the background refresh retries
+	staleRecheck time.Duration
+
+	// backgroundMu protects refreshing, and orders adding to background against Close
+	backgroundMu sync.Mutex
+
+	// refreshing holds the cache keys with a background refresh of a stale answer running
+	refreshing map[string]struct{}
+
+	// prefetches queues lookups whose cache entry should be refreshed before it expires, nil
+	// if prefetching is disabled
+	prefetches chan ipQuery
+
+	// ctx is canceled by Close, it stops all background work: prefetch workers and stale
+	// answer refreshes
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// background tracks goroutines doing background work, so Close can wait for them
+	background sync.WaitGroup
+
+	// closeOnce makes Close safe to call more than once
+	closeOnce sync.Once
+
+	// err is the first configuration error encountered while applying options
+	err error
+
+	// ipFlights coalesces concurrent IP lookups for the same host into one resolution
+	ipFlights flightGroup[[]net.IP]
+
+	// recordFlights does the same for lookups of a single record type, see lookupRecords
+	recordFlights flightGroup[[]Record]
 }
 
 // Logger provides structured logging throughout the resolution process.
@@ -84,7 +184,7 @@ func (noopLogger) Error(msg string, err error, fields ...Field) {}
 //   - Timeout: 2 seconds per query
 //   - Logger: no-op (no logging)
 //   - Pool size: 4 connections per resolver
-//   - Query types: [A, AAAA] (IPv4 and IPv6)
+//   - Query types: [A, AAAA] (can be set via WithQueryTypes)
 //   - Resolvers: none (must be set via WithResolvers)
 //   - Cache: disabled (can be enabled via WithCache)
 //
@@ -103,21 +203,137 @@ func New(opts ...Option) *Dialer {
 		logger:   noopLogger{},
 		poolSize: 4,
 		dialer:   &net.Dialer{},
-		cache:    newDNSCache(0, 0, 0), // disabled by default
+
+		maxCNAMEDepth: defaultMaxCNAMEDepth,
+		ndots:         defaultNdots,
+		queryTypes:    defaultQueryTypes,
+		attemptDelay:  defaultAttemptDelay,
+		sourceAddr:    newSourceCache(routeSourceAddr).sourceAddr,
+
+		// RFC 8767 suggests 30 seconds as the failure recheck timer
+		staleRecheck: 30 * time.Second,
+		refreshing:   make(map[string]struct{}),
 	}
 
 	for _, opt := range opts {
 		opt(r)
 	}
 
+	r.dial = r.dialer.DialContext
+
+	// The cache is disabled by default, unless WithCache set a size
+	r.cache = newDNSCache(r.cacheConfig)
+
+	r.chasers = r.newChasers(r.resolvers)
+
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+
+	if r.resolvConf.path != "" && r.resolvConf.interval > 0 {
+		r.watchResolvConf()
+	}
+
+	// Prefetching without a cache would have nothing to refresh
+	if r.cache.enabled && r.cacheConfig.prefetch.Workers > 0 {
+		r.startPrefetchers(r.cacheConfig.prefetch.Workers)
+	}
+
 	return r
 }
 
-// lookup performs DNS resolution using the configured strategy.
-// Always queries for A and AAAA records (IPv4 and IPv6).
-func (r *Dialer) lookup(ctx context.Context, host string) ([]Record, error) {
-	queryTypes := []RecordType{TypeA, TypeAAAA}
+// Err returns the first configuration error encountered while applying options, such as
+// a resolver address with an unsupported scheme, or nil if the configuration is valid.
+//
+// A Dialer with a configuration error refuses to dial, DialContext returns the same
+// error. Checking Err right after New lets you fail at startup instead.
+func (r *Dialer) Err() error {
+	return r.err
+}
 
+// Close stops the Dialer's background work, prefetching and refreshing of stale answers,
+// cancels the lookups in flight, and waits for all of them to finish. It then closes
+// every resolver that implements io.Closer, which releases the sockets and connections
+// of the built-in transports. Custom resolvers passed to WithCustomResolvers are closed
+// too if they implement io.Closer.
+//
+// After Close, DialContext returns an error wrapping net.ErrClosed. Close is safe to
+// call more than once, only the first call has an effect.
+func (r *Dialer) Close() error {
+	var err error
+	r.closeOnce.Do(func() {
+		// Cancel under backgroundMu, so no stale answer refresh or shared lookup can start
+		// once we're waiting
+		r.backgroundMu.Lock()
+		r.cancel()
+		r.backgroundMu.Unlock()
+		r.background.Wait()
+
+		resolvers, _ := r.activeResolvers()
+		for _, res := range resolvers {
+			if c, ok := res.(io.Closer); ok {
+				if cerr := c.Close(); cerr != nil && err == nil {
+					err = fmt.Errorf("closing resolver %s: %w", res.Name(), cerr)
+				}
+			}
+		}
+	})
+	return err
+}
+
+// DiscardedResponses returns, per resolver name, the number of responses that were
+// dropped because their message ID or question didn't match any query in flight.
+//
+// A few discards are normal, e.g. late replies to queries that already timed out. A
+// steadily growing count for one resolver can indicate spoofing attempts or a broken
+// middlebox on the path to it. Only resolvers that validate responses this way (UDP,
+// TCP and DNS-over-TLS) are included.
+func (r *Dialer) DiscardedResponses() map[string]uint64 {
+	counts := make(map[string]uint64)
+	resolvers, _ := r.activeResolvers()
+	for _, res := range resolvers {
+		if d, ok := res.(interface{ discardedResponses() uint64 }); ok {
+			counts[res.Name()] = d.discardedResponses()
+		}
+	}
+	return counts
+}
+
+// activeResolvers returns the resolvers currently in use, along with their CNAME-chasing
+// wrappers.
+func (r *Dialer) activeResolvers() (resolvers, chasers []Resolver) {
+	r.resolversMu.RLock()
+	defer r.resolversMu.RUnlock()
+	return r.resolvers, r.chasers
+}
+
+// swapResolvers replaces the resolvers in use and returns the previous ones. Lookups that
+// already started keep using the previous resolvers until they're done.
+func (r *Dialer) swapResolvers(resolvers []Resolver) []Resolver {
+	chasers := r.newChasers(resolvers)
+
+	r.resolversMu.Lock()
+	defer r.resolversMu.Unlock()
+	old := r.resolvers
+	r.resolvers, r.chasers = resolvers, chasers
+	return old
+}
+
+// newChasers wraps each of resolvers to follow CNAME chains.
+func (r *Dialer) newChasers(resolvers []Resolver) []Resolver {
+	chasers := make([]Resolver, len(resolvers))
+	for i, res := range resolvers {
+		chasers[i] = &cnameChaser{resolver: res, maxDepth: r.maxCNAMEDepth}
+	}
+	return chasers
+}
+
+// defaultQueryTypes are the record types we query when resolving a host to IP addresses.
+var defaultQueryTypes = []RecordType{TypeA, TypeAAAA}
+
+// lookup performs DNS resolution using the configured strategy, querying each of
+// queryTypes, A and AAAA unless configured otherwise with WithQueryTypes. If answered is
+// set, it's called with the records of every query type that succeeds while others are
+// still outstanding.
+func (r *Dialer) lookup(ctx context.Context, host string, queryTypes []RecordType, answered func([]Record)) ([]Record, error) {
 	type result struct {
 		records []Record
 		err     error
@@ -132,9 +348,10 @@ func (r *Dialer) lookup(ctx context.Context, host string) ([]Record, error) {
 	// we don't want to sit around waiting for A to complete before starting AAAA. This can
 	// significantly reduce total query time, especially when using strategies like Fallback
 	// that might need to try multiple resolvers sequentially per type.
+	_, chasers := r.activeResolvers()
 	for _, qtype := range queryTypes {
 		go func(qt RecordType) {
-			records, err := r.strategy.ResolveType(ctx, host, qt, r.resolvers, r.logger)
+			records, err := r.strategy.ResolveType(ctx, host, qt, chasers, r.logger)
 			results <- result{
 				records: records,
 				err:     err,
@@ -147,6 +364,7 @@ func (r *Dialer) lookup(ctx context.Context, host string) ([]Record, error) {
 	// approach here: if A records fail but AAAA succeeds, we'll return the AAAA records.
 	// Pre-allocate assuming ~4 records per type, just a heuristic based on typical responses.
 	allRecords := make([]Record, 0, len(queryTypes)*4)
+	var errs []error
 	for i := 0; i < len(queryTypes); i++ {
 		res := <-results
 		if res.err != nil {
@@ -156,34 +374,443 @@ func (r *Dialer) lookup(ctx context.Context, host string) ([]Record, error) {
 			r.logger.Debug("query type failed",
 				Field{"type", res.qtype.String()},
 				Field{"error", res.err.Error()})
+			errs = append(errs, res.err)
 			continue
 		}
 		allRecords = append(allRecords, res.records...)
+		if answered != nil && i < len(queryTypes)-1 {
+			answered(res.records)
+		}
+	}
+
+	// Only if every query type failed is the lookup as a whole a failure
+	if len(errs) == len(queryTypes) {
+		return nil, lookupError(host, errs)
 	}
 
 	return allRecords, nil
 }
 
-// lookupIPs extracts IP addresses from DNS records.
+// lookupError picks the error to report when every query type of a lookup failed.
+//
+// If all of them came back negative, the host has no addresses at all and we return a
+// single NotFoundError for it, which can be cached. Any other failure means we don't
+// actually know, so that error is returned instead.
+func lookupError(host string, errs []error) error {
+	var combined *NotFoundError
+	for _, err := range errs {
+		notFound, ok := negativeAnswer(err)
+		if !ok {
+			return err
+		}
+		combined = mergeNotFound(combined, notFound)
+	}
+	combined.Host = host
+	return combined
+}
+
+// lookupIPs resolves host to IP addresses, expanding it through the search list first if
+// one is configured.
 func (r *Dialer) lookupIPs(ctx context.Context, host string) ([]net.IP, error) {
+	return r.lookupIPTypes(ctx, host, r.queryTypes)
+}
+
+// lookupIPTypes resolves host to IP addresses like lookupIPs, querying only qtypes.
+func (r *Dialer) lookupIPTypes(ctx context.Context, host string, qtypes []RecordType) ([]net.IP, error) {
+	return r.lookupIPTypesEarly(ctx, host, qtypes, nil)
+}
+
+// lookupIPTypesEarly is like lookupIPTypes, but if the answer has to be resolved and early
+// is set, early is called with the addresses of the first query type answered while the
+// others are still outstanding. It's called at most once, and only for the name that
+// resolves, as the final answer always includes those addresses.
+func (r *Dialer) lookupIPTypesEarly(ctx context.Context, host string, qtypes []RecordType, early func([]net.IP)) ([]net.IP, error) {
+	if ips, source := r.lookupLocal(host); ips != nil {
+		r.logger.Debug("answered locally",
+			Field{"host", host},
+			Field{"source", source},
+			Field{"ips", len(ips)})
+		return ips, nil
+	}
+
+	if len(r.searchDomains) == 0 {
+		return r.lookupName(ctx, ipQuery{host: host, qtypes: qtypes}, early)
+	}
+
+	var firstErr error
+	for _, name := range r.searchNames(host) {
+		ips, err := r.lookupName(ctx, ipQuery{host: name, qtypes: qtypes}, early)
+		if err == nil {
+			return ips, nil
+		}
+
+		// Like glibc, only move on to the next name if this one doesn't exist. If the
+		// resolvers failed, we don't know whether it does, and a later name in the list
+		// might resolve to a different host than the one meant.
+		if _, negative := negativeAnswer(err); !negative {
+			return nil, err
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		r.logger.Debug("search name not found",
+			Field{"host", host},
+			Field{"name", name})
+	}
+	return nil, firstErr
+}
+
+// lookupName resolves a single name to IP addresses. It's cached under that exact name, so
+// with search domains the cache holds the expanded names rather than the short ones, and
+// the record types queried. early is passed on to resolveShared.
+func (r *Dialer) lookupName(ctx context.Context, q ipQuery, early func([]net.IP)) ([]net.IP, error) {
+	key := q.cacheKey()
+
 	// Fast path: check IP cache first, saves us from parsing strings each time
-	if cached := r.cache.getIPs(host); cached != nil {
+	if cached, prefetch := r.cache.getIPs(key); cached != nil {
 		r.logger.Debug("IP cache hit",
-			Field{"host", host},
+			Field{"host", q.host},
 			Field{"ips", len(cached)})
+		if prefetch {
+			r.prefetch(q)
+		}
 		return cached, nil
 	}
 
+	if notFound := r.cache.getNegative(key); notFound != nil {
+		// The key may have the record types added, report the host itself
+		notFound.Host = q.host
+		r.logger.Debug("negative cache hit",
+			Field{"host", q.host},
+			Field{"nxdomain", notFound.NXDomain})
+		return nil, notFound
+	}
+
 	r.logger.Debug("IP cache miss",
-		Field{"host", host})
+		Field{"host", q.host})
+
+	// With serve-stale, an expired answer may still be around. If refreshing it failed only
+	// recently, the resolvers are most likely still down, so we don't make the caller wait
+	// for them to fail again. The background refresh takes care of trying again.
+	stale, hasStale := r.cache.getStaleIPs(key)
+	if hasStale && !stale.failedAt.IsZero() && time.Since(stale.failedAt) < r.staleRecheck {
+		r.logger.Info("serving stale answer",
+			Field{"host", q.host},
+			Field{"stale", true},
+			Field{"age", stale.age.String()})
+		r.refreshStale(q)
+		return stale.ips, nil
+	}
+
+	// A negative answer isn't a failure to resolve, the host is really gone, so there's no
+	// stale answer to fall back to in that case.
+	ips, err := r.resolveShared(ctx, q, early)
+	if _, negative := negativeAnswer(err); err != nil && hasStale && ctx.Err() == nil && !negative {
+		// Every resolver failed, but an answer that expired not too long ago beats no answer
+		// at all (RFC 8767). Serve it and keep trying to refresh it in the background.
+		r.cache.markFailed(key)
+		r.logger.Info("serving stale answer",
+			Field{"host", q.host},
+			Field{"stale", true},
+			Field{"age", stale.age.String()},
+			Field{"error", err.Error()})
+		r.refreshStale(q)
+		return stale.ips, nil
+	}
+	return ips, err
+}
+
+// resolveShared resolves host, sharing the resolution with concurrent lookups for the same
+// host, so a burst of dials on a cold cache doesn't turn into a burst of identical queries
+// to every resolver.
+//
+// If early is set, it's called with the addresses of the first query type answered, if
+// that comes in while the others are still outstanding, so a dial can start on them.
+func (r *Dialer) resolveShared(ctx context.Context, q ipQuery, early func([]net.IP)) ([]net.IP, error) {
+	ips, shared, err := r.ipFlights.doEarly(ctx, flightKey(q.host, q.qtypes), func(ctx context.Context, publish func([]net.IP)) ([]net.IP, error) {
+		return untilClose(r, func(ctx context.Context) ([]net.IP, error) {
+			return r.resolveIPs(ctx, q, publish)
+		})(ctx)
+	}, early)
+	if shared {
+		r.logger.Debug("joined in-flight lookup",
+			Field{"host", q.host})
+	}
+	return ips, err
+}
+
+// untilClose wraps fn, the shared part of a lookup, to run as background work of the Dialer.
+//
+// Shared lookups are detached from the callers' contexts, so they'd otherwise keep using
+// the resolvers and writing to the cache after Close. Instead, fn's context is canceled
+// when the Dialer is closed, and Close waits for fn to return.
+func untilClose[T any](r *Dialer, fn func(ctx context.Context) (T, error)) func(ctx context.Context) (T, error) {
+	return func(ctx context.Context) (T, error) {
+		r.backgroundMu.Lock()
+		if r.ctx.Err() != nil {
+			r.backgroundMu.Unlock()
+			var zero T
+			return zero, fmt.Errorf("dialer closed: %w", net.ErrClosed)
+		}
+		r.background.Add(1)
+		r.backgroundMu.Unlock()
+		defer r.background.Done()
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		stop := context.AfterFunc(r.ctx, cancel)
+		defer stop()
+
+		return fn(ctx)
+	}
+}
+
+// refreshStale starts a background refresh of the stale answer for host, unless one is
+// already running. It retries every staleRecheck until resolution succeeds, or the answer
+// falls out of the stale window or gets refreshed by a regular lookup.
+func (r *Dialer) refreshStale(q ipQuery) {
+	key := q.cacheKey()
 
-	// Cache miss - time to do the actual DNS lookup
-	records, err := r.lookup(ctx, host)
+	r.backgroundMu.Lock()
+	if _, ok := r.refreshing[key]; ok || r.ctx.Err() != nil {
+		r.backgroundMu.Unlock()
+		return
+	}
+	r.refreshing[key] = struct{}{}
+	r.background.Add(1)
+	r.backgroundMu.Unlock()
+
+	go func() {
+		defer r.background.Done()
+		defer func() {
+			r.backgroundMu.Lock()
+			delete(r.refreshing, key)
+			r.backgroundMu.Unlock()
+		}()
+
+		timer := time.NewTimer(r.staleRecheck)
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-timer.C:
+			case <-r.ctx.Done():
+				return
+			}
+			timer.Reset(r.staleRecheck)
+
+			if _, ok := r.cache.getStaleIPs(key); !ok {
+				return
+			}
+
+			if _, err := r.resolveShared(r.ctx, q, nil); err != nil {
+				r.cache.markFailed(key)
+				r.logger.Debug("stale answer refresh failed",
+					Field{"host", q.host},
+					Field{"error", err.Error()})
+				continue
+			}
+
+			r.logger.Debug("stale answer refreshed",
+				Field{"host", q.host})
+			return
+		}
+	}()
+}
+
+// queryTypesFor returns the configured query types that can yield addresses usable on
+// network: only A for networks ending in 4, like tcp4 or ip4, only AAAA for those ending
+// in 6, and all of them otherwise.
+func (r *Dialer) queryTypesFor(network string) []RecordType {
+	var family RecordType
+	switch {
+	case strings.HasSuffix(network, "4"):
+		family = TypeA
+	case strings.HasSuffix(network, "6"):
+		family = TypeAAAA
+	default:
+		return r.queryTypes
+	}
+
+	if slices.Contains(r.queryTypes, family) {
+		return []RecordType{family}
+	}
+	return nil
+}
+
+// ipQuery is a lookup of the addresses of host, querying the record types in qtypes.
+type ipQuery struct {
+	host   string
+	qtypes []RecordType
+}
+
+// cacheKey identifies the answer to the query in the cache. Answers to the default query
+// types are cached under the host alone, others get the types added, so an answer with
+// only the IPv4 addresses of a host isn't mistaken for all of them.
+func (q ipQuery) cacheKey() string {
+	if slices.Equal(q.qtypes, defaultQueryTypes) {
+		return q.host
+	}
+	return flightKey(q.host, q.qtypes)
+}
+
+// flightKey identifies a lookup for coalescing, lookups only share a result if they ask
+// the same question.
+func flightKey(host string, qtypes []RecordType) string {
+	var b strings.Builder
+	b.WriteString(host)
+	for _, qtype := range qtypes {
+		b.WriteByte('|')
+		b.WriteString(qtype.String())
+	}
+	return b.String()
+}
+
+// lookupRecords resolves the records of qtype for host through the configured strategy,
+// with the same caching and coalescing of concurrent lookups as the IP path. It backs the
+// Lookup methods, which need records other than A and AAAA.
+//
+// Only records of qtype are returned, e.g. the CNAME records a resolver includes in front
+// of the MX records of an alias are left out. If there are none, the error is a
+// *NotFoundError.
+func (r *Dialer) lookupRecords(ctx context.Context, host string, qtype RecordType) ([]Record, error) {
+	records, notFound := r.cache.getRecords(host, qtype)
+	if records != nil {
+		r.logger.Debug("record cache hit",
+			Field{"host", host},
+			Field{"type", qtype.String()},
+			Field{"records", len(records)})
+		return records, nil
+	}
+	if notFound != nil {
+		r.logger.Debug("negative cache hit",
+			Field{"host", host},
+			Field{"type", qtype.String()},
+			Field{"nxdomain", notFound.NXDomain})
+		return nil, notFound
+	}
+
+	r.logger.Debug("record cache miss",
+		Field{"host", host},
+		Field{"type", qtype.String()})
+
+	records, shared, err := r.recordFlights.do(ctx, flightKey(host, []RecordType{qtype}), untilClose(r, func(ctx context.Context) ([]Record, error) {
+		_, chasers := r.activeResolvers()
+		records, err := r.strategy.ResolveType(ctx, host, qtype, chasers, r.logger)
+		if err != nil {
+			if notFound, ok := negativeAnswer(err); ok {
+				r.cache.setNegativeRecords(host, qtype, notFound)
+			}
+			return nil, err
+		}
+
+		matching := make([]Record, 0, len(records))
+		for _, record := range records {
+			if record.Type == qtype {
+				matching = append(matching, record)
+			}
+		}
+		if len(matching) == 0 {
+			return nil, &NotFoundError{Host: host}
+		}
+
+		r.cache.setRecords(host, qtype, matching)
+		return matching, nil
+	}))
+	if shared {
+		r.logger.Debug("joined in-flight lookup",
+			Field{"host", host},
+			Field{"type", qtype.String()})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The records are shared with the cache and other callers, so everyone gets a copy
+	return append([]Record(nil), records...), nil
+}
+
+// startPrefetchers starts the workers that refresh cache entries queued by prefetch. They
+// run until Close.
+func (r *Dialer) startPrefetchers(workers int) {
+	// A few queued hosts per worker absorb bursts, anything beyond that is dropped rather
+	// than piling up while the resolvers are slow
+	r.prefetches = make(chan ipQuery, workers*4)
+
+	for i := 0; i < workers; i++ {
+		r.background.Add(1)
+		go func() {
+			defer r.background.Done()
+			for {
+				select {
+				case q := <-r.prefetches:
+					// The entry is still valid, so a failure here isn't a problem for anybody. It
+					// just expires and the next lookup resolves it as usual.
+					if _, err := r.resolveShared(r.ctx, q, nil); err != nil {
+						r.logger.Debug("prefetch failed",
+							Field{"host", q.host},
+							Field{"error", err.Error()})
+						continue
+					}
+					r.logger.Debug("prefetched",
+						Field{"host", q.host})
+				case <-r.ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+}
+
+// prefetch queues host to have its cache entry refreshed in the background. It never
+// blocks, if the queue is full the prefetch is dropped.
+func (r *Dialer) prefetch(q ipQuery) {
+	if r.prefetches == nil {
+		return
+	}
+
+	select {
+	case r.prefetches <- q:
+	default:
+		r.logger.Debug("prefetch queue full, dropping prefetch",
+			Field{"host", q.host})
+	}
+}
+
+// resolveIPs resolves host through the configured strategy and caches the resulting IPs.
+// The addresses of a query type answered before the others are passed to publish.
+func (r *Dialer) resolveIPs(ctx context.Context, q ipQuery, publish func([]net.IP)) ([]net.IP, error) {
+	records, err := r.lookup(ctx, q.host, q.qtypes, func(records []Record) {
+		if ips, _ := recordIPs(records); len(ips) > 0 {
+			publish(ips)
+		}
+	})
 	if err != nil {
+		// Remember that the host has no addresses, so repeated dials to a name that doesn't
+		// exist don't hit every resolver each time (RFC 2308)
+		if notFound, ok := negativeAnswer(err); ok {
+			r.cache.setNegative(q.cacheKey(), notFound.NXDomain, notFound.ttl)
+		}
 		return nil, err
 	}
 
-	// Extract IPs and find minimum TTL for caching, we need to honor the lowest one
+	ips, minTTL := recordIPs(records)
+
+	// The lookup succeeded, but without any addresses, e.g. only a CNAME came back. There's
+	// no SOA to tell how long that holds, so it isn't cached.
+	if len(ips) == 0 {
+		return nil, &NotFoundError{Host: q.host}
+	}
+
+	// Cache the IPs for future lookups so we can skip the parsing overhead next time
+	r.cache.setIPs(q.cacheKey(), ips, time.Duration(minTTL)*time.Second)
+
+	return ips, nil
+}
+
+// recordIPs extracts the IP addresses from the A and AAAA records among records, along with
+// the minimum TTL of those records for caching, we need to honor the lowest one.
+func recordIPs(records []Record) ([]net.IP, uint32) {
 	ips := make([]net.IP, 0, len(records))
 	minTTL := uint32(300) // Default 5 minutes if we don't find a TTL, shouldn't happen in practice
 
@@ -200,15 +827,33 @@ func (r *Dialer) lookupIPs(ctx context.Context, host string) ([]net.IP, error) {
 			}
 		}
 	}
+	return ips, minTTL
+}
 
-	if len(ips) == 0 {
-		return nil, fmt.Errorf("no IP addresses found for %s", host)
+// Query resolves the records of the given type for host through the configured strategy.
+//
+// It goes through the same cache, coalescing of concurrent lookups and logging as the IP
+// lookups of DialContext, so it's the way to get at record types other than A and AAAA,
+// e.g. TXT records for domain verification or SRV records for service discovery.
+//
+// Only records of qtype are returned. If there are none, the error matches ErrNXDomain
+// or ErrNoData, and can be inspected as a *NotFoundError. Other failures are reported as
+// returned by the strategy, e.g. an *AggregateError if every resolver failed.
+//
+// Example:
+//
+//	records, err := dialer.Query(ctx, "example.com", TypeTXT)
+//	if err != nil {
+//	    return err
+//	}
+//	for _, record := range records {
+//	    fmt.Println(record.Value)
+//	}
+func (r *Dialer) Query(ctx context.Context, host string, qtype RecordType) ([]Record, error) {
+	if err := r.checkUsable(); err != nil {
+		return nil, err
 	}
-
-	// Cache the IPs for future lookups so we can skip the parsing overhead next time
-	r.cache.setIPs(host, ips, time.Duration(minTTL)*time.Second)
-
-	return ips, nil
+	return r.lookupRecords(ctx, host, qtype)
 }
 
 // DialContext implements the net.Dialer.DialContext signature, making it a drop-in replacement
@@ -231,6 +876,12 @@ func (r *Dialer) lookupIPs(ctx context.Context, host string) ([]net.IP, error) {
 //	// Custom usage
 //	conn, err := dialer.DialContext(ctx, "tcp", "api.github.com:443")
 func (r *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	// Don't try to make do with a partially applied configuration, e.g. with one of the
+	// resolvers missing because its address couldn't be parsed.
+	if err := r.checkUsable(); err != nil {
+		return nil, err
+	}
+
 	// Split addr into host and port (standard net package format)
 	host, portStr, err := net.SplitHostPort(addr)
 	if err != nil {
@@ -240,13 +891,28 @@ func (r *Dialer) DialContext(ctx context.Context, network, addr string) (net.Con
 	// If host is already an IP address, use it directly without DNS lookup. No point
 	// in doing DNS resolution for something that's already an IP.
 	if ip := net.ParseIP(host); ip != nil {
-		return r.dialer.DialContext(ctx, network, addr)
+		return r.dial(ctx, network, addr)
+	}
+
+	// Only ask for the address family the network can use, e.g. a tcp4 dial has no use for
+	// AAAA records, so there's no point in waiting for them
+	qtypes := r.queryTypesFor(network)
+	if len(qtypes) == 0 {
+		return nil, fmt.Errorf("no suitable IP addresses found for %s (network: %s)", host, network)
+	}
+
+	// With both address families to resolve, start connecting as soon as the first one is
+	// answered, so a slow AAAA or A query doesn't hold up the dial (RFC 8305 section 3)
+	if network == "tcp" && len(qtypes) > 1 {
+		return r.dialResolving(ctx, network, host, portStr, qtypes)
 	}
 
 	// Perform DNS lookup using whichever strategy is configured
-	ips, err := r.lookupIPs(ctx, host)
+	// Report lookup failures the way net.Dialer does, so callers written against the standard
+	// library (e.g. checking for *net.DNSError) handle them the same
+	ips, err := r.lookupIPTypes(ctx, host, qtypes)
 	if err != nil {
-		return nil, fmt.Errorf("DNS lookup failed for %s: %w", host, err)
+		return nil, &net.OpError{Op: "dial", Net: network, Err: newDNSError(host, err)}
 	}
 
 	// Filter IPs based on network type
@@ -267,40 +933,13 @@ func (r *Dialer) DialContext(ctx context.Context, network, addr string) (net.Con
 			}
 		}
 	default:
-		// For "tcp" and "udp", use all IPs we got. Try IPv4 first for better compatibility,
-		// more things support IPv4 than IPv6 in practice.
-		filteredIPs = make([]net.IP, 0, len(ips))
-		// Add IPv4 addresses first
-		for _, ip := range ips {
-			if ip.To4() != nil {
-				filteredIPs = append(filteredIPs, ip)
-			}
-		}
-		// Then add IPv6 addresses
-		for _, ip := range ips {
-			if ip.To4() == nil && ip.To16() != nil {
-				filteredIPs = append(filteredIPs, ip)
-			}
-		}
+		// For "tcp" and "udp", use all IPs we got
+		filteredIPs = ips
 	}
 
 	if len(filteredIPs) == 0 {
 		return nil, fmt.Errorf("no suitable IP addresses found for %s (network: %s)", host, network)
 	}
 
-	var lastErr error
-	for _, ip := range filteredIPs {
-		ipAddr := net.JoinHostPort(ip.String(), portStr)
-		conn, err := r.dialer.DialContext(ctx, network, ipAddr)
-		if err == nil {
-			return conn, nil
-		}
-
-		lastErr = err
-		r.logger.Debug("connection failed, trying next IP",
-			Field{"ip", ip.String()},
-			Field{"error", err.Error()})
-	}
-
-	return nil, fmt.Errorf("failed to connect to %s: %w", host, lastErr)
+	return r.dialAddrs(ctx, network, host, portStr, r.dialOrder(network, filteredIPs), nil)
 }
@@ -11,9 +11,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
@@ -116,3 +119,112 @@ func TestDialer_DialContext_gRPC(t *testing.T) {
 
 	conn.Close()
 }
+
+func TestDialer_DialContext_CustomResolver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	serverURL, _ := url.Parse(server.URL)
+	_, port, _ := net.SplitHostPort(serverURL.Host)
+
+	// The custom resolver is the only one configured, so the name can only resolve through it
+	dialer := New(
+		WithCustomResolvers(&mockResolver{
+			name:     "registry",
+			response: []Record{{Type: TypeA, Value: "127.0.0.1", TTL: 300}},
+		}),
+		WithStrategy(Fallback{}),
+	)
+
+	conn, err := dialer.DialContext(context.Background(), "tcp", net.JoinHostPort("payments.internal", port))
+
+	assert.NoError(t, err)
+	assert.NotNil(t, conn)
+	conn.Close()
+}
+
+// typeRecordingResolver answers with a loopback address of the requested type, and records
+// the types it was asked for.
+type typeRecordingResolver struct {
+	mu    sync.Mutex
+	types []RecordType
+}
+
+func (t *typeRecordingResolver) ResolveType(ctx context.Context, host string, qtype RecordType) ([]Record, error) {
+	t.mu.Lock()
+	t.types = append(t.types, qtype)
+	t.mu.Unlock()
+
+	switch qtype {
+	case TypeA:
+		return []Record{{Type: TypeA, Value: "127.0.0.1", TTL: 300}}, nil
+	case TypeAAAA:
+		return []Record{{Type: TypeAAAA, Value: "::1", TTL: 300}}, nil
+	}
+	return nil, &NotFoundError{Host: host}
+}
+
+func (t *typeRecordingResolver) Name() string {
+	return "recording"
+}
+
+func (t *typeRecordingResolver) queried() []RecordType {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	queried := t.types
+	t.types = nil
+	return queried
+}
+
+func TestDialer_QueryTypes(t *testing.T) {
+	res := &typeRecordingResolver{}
+	dialer := New(
+		WithCustomResolvers(res),
+		WithQueryTypes(TypeA, TypeMX),
+	)
+	defer dialer.Close()
+	ctx := context.Background()
+
+	hosts, err := dialer.LookupHost(ctx, "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"127.0.0.1"}, hosts)
+	assert.Equal(t, []RecordType{TypeA}, res.queried())
+
+	// IPv6 was never asked for, so there's nothing to query
+	_, err = dialer.LookupIP(ctx, "ip6", "example.com")
+	assert.Error(t, err)
+	assert.Empty(t, res.queried())
+
+	_, err = dialer.DialContext(ctx, "tcp6", "example.com:80")
+	assert.Error(t, err)
+	assert.Empty(t, res.queried())
+}
+
+func TestDialer_DialContext_SingleFamily(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	res := &typeRecordingResolver{}
+	dialer := New(
+		WithCustomResolvers(res),
+		WithCache(10, time.Second, time.Minute),
+	)
+	defer dialer.Close()
+	ctx := context.Background()
+
+	// A tcp4 dial has no use for the AAAA records
+	conn, err := dialer.DialContext(ctx, "tcp4", net.JoinHostPort("example.com", port))
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, []RecordType{TypeA}, res.queried())
+
+	// The IPv4 addresses alone aren't the answer for a dual-stack lookup
+	ips, err := dialer.LookupIP(ctx, "ip", "example.com")
+	require.NoError(t, err)
+	assert.Len(t, ips, 2)
+	assert.ElementsMatch(t, []RecordType{TypeA, TypeAAAA}, res.queried())
+}
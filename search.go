@@ -0,0 +1,41 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import "strings"
+
+const (
+	// defaultNdots matches the resolv.conf default
+	defaultNdots = 1
+
+	// maxNdots is the highest ndots value glibc accepts
+	maxNdots = 15
+)
+
+// searchNames returns the names to try for host, in order, following glibc's rules.
+//
+// A fully-qualified name, one ending in a dot, is only tried as is. Otherwise, a name with
+// at least ndots dots is likely fully qualified already, so it's tried as is before the
+// search list. A name with fewer dots, e.g. "payments", is most likely relative and goes
+// through the search list first.
+func (r *Dialer) searchNames(host string) []string {
+	if strings.HasSuffix(host, ".") {
+		return []string{host}
+	}
+
+	names := make([]string, 0, len(r.searchDomains)+1)
+	asIs := strings.Count(host, ".") >= r.ndots
+	if asIs {
+		names = append(names, host)
+	}
+	for _, domain := range r.searchDomains {
+		names = append(names, host+"."+domain)
+	}
+	if !asIs {
+		names = append(names, host)
+	}
+	return names
+}
@@ -0,0 +1,60 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialer_SearchNames(t *testing.T) {
+	tests := []struct {
+		host  string
+		ndots int
+		names []string
+	}{
+		{host: "payments", ndots: 1, names: []string{"payments.svc.local", "payments.local", "payments"}},
+		{host: "payments.svc", ndots: 1, names: []string{"payments.svc", "payments.svc.svc.local", "payments.svc.local"}},
+		{host: "api.example.com", ndots: 5, names: []string{"api.example.com.svc.local", "api.example.com.local", "api.example.com"}},
+		{host: "payments", ndots: 0, names: []string{"payments", "payments.svc.local", "payments.local"}},
+		{host: "example.com.", ndots: 5, names: []string{"example.com."}},
+	}
+
+	for _, tt := range tests {
+		dialer := New(WithSearchDomains("svc.local.", "local"), WithNdots(tt.ndots))
+		assert.Equal(t, tt.names, dialer.searchNames(tt.host), tt.host)
+	}
+}
+
+func TestDialer_SearchDomains(t *testing.T) {
+	addr, _ := startTestZoneServer(t)
+	dialer := New(
+		WithResolvers(addr),
+		WithSearchDomains("svc.example.org", "example.com"),
+		WithCache(10, time.Second, time.Minute),
+	)
+	defer dialer.Close()
+	ctx := context.Background()
+
+	ips, err := dialer.LookupIP(ctx, "ip4", "www")
+	require.NoError(t, err)
+	assert.Equal(t, []net.IP{net.ParseIP("192.0.2.1")}, ips)
+
+	// Cached under the name that resolved
+	cached, _ := dialer.cache.getIPs(ipQuery{host: "www.example.com", qtypes: []RecordType{TypeA}}.cacheKey())
+	assert.NotNil(t, cached)
+
+	_, err = dialer.LookupIP(ctx, "ip4", "missing")
+	var dnsErr *net.DNSError
+	require.ErrorAs(t, err, &dnsErr)
+	assert.True(t, dnsErr.IsNotFound)
+	assert.Equal(t, "missing", dnsErr.Name)
+}
@@ -0,0 +1,149 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"context"
+	"sync"
+)
+
+// flightGroup coalesces concurrent calls with the same key into a single in-flight call
+// whose result is shared by every caller.
+//
+// This matters on a cold cache: a burst of dials to the same host (e.g. an http.Transport
+// opening many connections at once) would otherwise run the whole strategy once per dial,
+// multiplying the load on every resolver for the exact same answer.
+//
+// Unlike golang.org/x/sync/singleflight, the shared call isn't tied to the context of the
+// caller that happened to start it. It keeps running as long as at least one caller is still
+// waiting, and is only canceled once all of them have given up.
+//
+// The zero value is ready to use.
+type flightGroup[T any] struct {
+	// mu protects calls and the waiter counts of the calls in it
+	mu sync.Mutex
+
+	// calls maps keys to the call currently in flight for them
+	calls map[string]*flight[T]
+}
+
+// flight is a single in-flight call shared by one or more waiters.
+type flight[T any] struct {
+	// done is closed once val and err are set
+	done chan struct{}
+
+	val T
+	err error
+
+	// early is closed once the call has published an early result, earlyVal
+	early       chan struct{}
+	earlyVal    T
+	publishOnce sync.Once
+
+	// waiters is the number of callers still waiting for the result
+	waiters int
+
+	// cancel aborts the call, used once the last waiter has gone
+	cancel context.CancelFunc
+}
+
+// do calls fn once for all concurrent callers with the same key and returns its result
+// to each of them. The returned bool reports whether the result came from a call started
+// by another caller.
+//
+// fn receives a context that carries the values of the first caller's context, but not its
+// cancellation or deadline. It is canceled once every caller's context is done, or when fn
+// returns. Callers whose context is done return right away with the context's error.
+func (g *flightGroup[T]) do(ctx context.Context, key string, fn func(ctx context.Context) (T, error)) (T, bool, error) {
+	return g.doEarly(ctx, key, func(ctx context.Context, _ func(T)) (T, error) {
+		return fn(ctx)
+	}, nil)
+}
+
+// doEarly is like do, but fn may publish an early result before it returns, e.g. the answer
+// to the first of several queries. Only the first one published counts. Every caller that
+// passes early has it called with that result once it's in, unless the final result is
+// already in by then. early runs on the caller's goroutine, before doEarly returns.
+func (g *flightGroup[T]) doEarly(ctx context.Context, key string, fn func(ctx context.Context, publish func(T)) (T, error), early func(T)) (T, bool, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flight[T])
+	}
+
+	if f, ok := g.calls[key]; ok {
+		f.waiters++
+		g.mu.Unlock()
+		val, err := g.wait(ctx, key, f, early)
+		return val, true, err
+	}
+
+	fctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	f := &flight[T]{
+		done:    make(chan struct{}),
+		early:   make(chan struct{}),
+		waiters: 1,
+		cancel:  cancel,
+	}
+	g.calls[key] = f
+	g.mu.Unlock()
+
+	// Run the call in its own goroutine, so that the caller who started it can still give up
+	// without taking the result away from everybody else.
+	go func() {
+		defer cancel()
+		f.val, f.err = fn(fctx, func(val T) {
+			f.publishOnce.Do(func() {
+				f.earlyVal = val
+				close(f.early)
+			})
+		})
+
+		g.mu.Lock()
+		if g.calls[key] == f {
+			delete(g.calls, key)
+		}
+		g.mu.Unlock()
+
+		close(f.done)
+	}()
+
+	val, err := g.wait(ctx, key, f, early)
+	return val, false, err
+}
+
+// wait blocks until f completes or ctx is done, whichever comes first. If early is set, it's
+// called with the early result of f if one comes in before that.
+func (g *flightGroup[T]) wait(ctx context.Context, key string, f *flight[T], early func(T)) (T, error) {
+	var published chan struct{}
+	if early != nil {
+		published = f.early
+	}
+
+	for {
+		select {
+		case <-f.done:
+			return f.val, f.err
+		case <-published:
+			early(f.earlyVal)
+			published = nil
+		case <-ctx.Done():
+			g.mu.Lock()
+			f.waiters--
+			if f.waiters == 0 {
+				// Nobody is interested in the result anymore. Abort the call and make sure new
+				// callers start a fresh one rather than joining the canceled one.
+				f.cancel()
+				if g.calls[key] == f {
+					delete(g.calls, key)
+				}
+			}
+			g.mu.Unlock()
+
+			var zero T
+			return zero, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingResolver is a mockResolver that counts how often it was queried.
+type countingResolver struct {
+	mockResolver
+	calls atomic.Int32
+}
+
+func (c *countingResolver) ResolveType(ctx context.Context, host string, qtype RecordType) ([]Record, error) {
+	c.calls.Add(1)
+	return c.mockResolver.ResolveType(ctx, host, qtype)
+}
+
+func TestFlightGroup_CoalescesConcurrentCalls(t *testing.T) {
+	var g flightGroup[int]
+	var calls atomic.Int32
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+	results := make(chan int, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			val, _, err := g.do(context.Background(), "key", func(ctx context.Context) (int, error) {
+				calls.Add(1)
+				<-release
+				return 42, nil
+			})
+			assert.NoError(t, err)
+			results <- val
+		}()
+	}
+
+	// Give every goroutine the chance to join before the call completes
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+
+	assert.Equal(t, int32(1), calls.Load())
+	for val := range results {
+		assert.Equal(t, 42, val)
+	}
+}
+
+func TestFlightGroup_CancelOneWaiter(t *testing.T) {
+	var g flightGroup[int]
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var callCtx context.Context
+
+	fn := func(ctx context.Context) (int, error) {
+		callCtx = ctx
+		close(started)
+		<-release
+		return 42, nil
+	}
+
+	// The first caller starts the call and then gives up
+	ctx, cancel := context.WithCancel(context.Background())
+	firstDone := make(chan error)
+	go func() {
+		_, _, err := g.do(ctx, "key", fn)
+		firstDone <- err
+	}()
+	<-started
+
+	secondDone := make(chan int)
+	go func() {
+		val, shared, err := g.do(context.Background(), "key", fn)
+		assert.NoError(t, err)
+		assert.True(t, shared)
+		secondDone <- val
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	cancel()
+	assert.ErrorIs(t, <-firstDone, context.Canceled)
+
+	// The second caller is still waiting, so the shared call must keep going
+	assert.NoError(t, callCtx.Err())
+	close(release)
+	assert.Equal(t, 42, <-secondDone)
+}
+
+func TestFlightGroup_CancelAllWaiters(t *testing.T) {
+	var g flightGroup[int]
+	aborted := make(chan struct{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, _, err := g.do(ctx, "key", func(ctx context.Context) (int, error) {
+			<-ctx.Done()
+			close(aborted)
+			return 0, ctx.Err()
+		})
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+
+	select {
+	case <-aborted:
+	case <-time.After(time.Second):
+		t.Fatal("shared call was not canceled after all waiters left")
+	}
+}
+
+func TestDialer_LookupIPs_Coalesced(t *testing.T) {
+	res := &countingResolver{mockResolver: mockResolver{
+		name:     "slow",
+		response: []Record{{Type: TypeA, Value: "192.0.2.1", TTL: 300}},
+		delay:    50 * time.Millisecond,
+	}}
+	dialer := New(WithCustomResolvers(res))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ips, err := dialer.lookupIPs(context.Background(), "example.com")
+			assert.NoError(t, err)
+			assert.NotEmpty(t, ips)
+		}()
+	}
+	wg.Wait()
+
+	// One query per record type (A and AAAA), not one per caller
+	assert.Equal(t, int32(2), res.calls.Load())
+}
+
+func TestFlightGroup_EarlyResult(t *testing.T) {
+	var g flightGroup[int]
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+	early := make(chan int, 10)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			val, _, err := g.doEarly(context.Background(), "key", func(ctx context.Context, publish func(int)) (int, error) {
+				publish(1)
+				publish(2)
+				<-release
+				return 3, nil
+			}, func(val int) {
+				early <- val
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, 3, val)
+		}()
+	}
+
+	// Every waiter gets the first early result while the call is still running
+	for i := 0; i < 3; i++ {
+		select {
+		case val := <-early:
+			assert.Equal(t, 1, val)
+		case <-time.After(time.Second):
+			t.Fatal("early result not delivered")
+		}
+	}
+	close(release)
+	wg.Wait()
+	assert.Empty(t, early)
+}
@@ -15,7 +15,7 @@ import (
 // changing the calling code. The strategy receives all configured resolvers and
 // decides how to query them (sequentially, concurrently, with consensus, etc.).
 type Strategy interface {
-	ResolveType(ctx context.Context, host string, qtype RecordType, resolvers []resolver, logger Logger) ([]Record, error)
+	ResolveType(ctx context.Context, host string, qtype RecordType, resolvers []Resolver, logger Logger) ([]Record, error)
 }
 
 // Race queries all resolvers simultaneously and returns the first successful response.
@@ -35,7 +35,16 @@ type Consensus struct {
 }
 
 // Fallback tries resolvers sequentially in order until one succeeds.
-type Fallback struct{}
+type Fallback struct {
+	// Attempts is the number of times the list of resolvers is tried before giving up,
+	// like the attempts option in resolv.conf. If 0, each resolver is tried once.
+	Attempts int
+
+	// Rotate, when true, starts each query at a randomly picked resolver instead of the
+	// first one, spreading the load across all of them like the rotate option in
+	// resolv.conf. Later resolvers are still tried in order when one fails.
+	Rotate bool
+}
 
 // Compare queries all resolvers and detects discrepancies without failing on them.
 type Compare struct {
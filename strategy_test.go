@@ -12,9 +12,10 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-// mockResolver implements the resolver interface for testing
+// mockResolver implements the Resolver interface for testing
 type mockResolver struct {
 	name     string
 	response []Record
@@ -66,7 +67,7 @@ func TestRace_FirstSuccessfulResponse(t *testing.T) {
 	ctx := context.Background()
 	logger := &mockLogger{}
 
-	resolvers := []resolver{
+	resolvers := []Resolver{
 		&mockResolver{name: "slow", response: []Record{{Value: "1.1.1.1", TTL: 300}}, delay: 100 * time.Millisecond},
 		&mockResolver{name: "fast", response: []Record{{Value: "2.2.2.2", TTL: 300}}, delay: 10 * time.Millisecond},
 	}
@@ -83,7 +84,7 @@ func TestRace_AllResolversFail(t *testing.T) {
 	ctx := context.Background()
 	logger := &mockLogger{}
 
-	resolvers := []resolver{
+	resolvers := []Resolver{
 		&mockResolver{name: "resolver1", err: errors.New("timeout")},
 		&mockResolver{name: "resolver2", err: errors.New("server failure")},
 	}
@@ -99,7 +100,7 @@ func TestConsensus_MinorityAgreement_Fails(t *testing.T) {
 	ctx := context.Background()
 	logger := &mockLogger{}
 
-	resolvers := []resolver{
+	resolvers := []Resolver{
 		&mockResolver{name: "resolver1", response: []Record{{Value: "1.1.1.1", TTL: 300}}},
 		&mockResolver{name: "resolver2", response: []Record{{Value: "2.2.2.2", TTL: 300}}},
 		&mockResolver{name: "resolver3", response: []Record{{Value: "3.3.3.3", TTL: 300}}},
@@ -116,7 +117,7 @@ func TestConsensus_MajorityAgreement_Succeeds(t *testing.T) {
 	ctx := context.Background()
 	logger := &mockLogger{}
 
-	resolvers := []resolver{
+	resolvers := []Resolver{
 		&mockResolver{name: "resolver1", response: []Record{{Value: "1.1.1.1", TTL: 300}}},
 		&mockResolver{name: "resolver2", response: []Record{{Value: "1.1.1.1", TTL: 300}}},
 		&mockResolver{name: "resolver3", response: []Record{{Value: "2.2.2.2", TTL: 300}}},
@@ -134,7 +135,7 @@ func TestConsensus_IgnoreTTL(t *testing.T) {
 	ctx := context.Background()
 	logger := &mockLogger{}
 
-	resolvers := []resolver{
+	resolvers := []Resolver{
 		&mockResolver{name: "resolver1", response: []Record{{Value: "1.1.1.1", TTL: 300}}},
 		&mockResolver{name: "resolver2", response: []Record{{Value: "1.1.1.1", TTL: 600}}},
 	}
@@ -152,7 +153,7 @@ func TestConsensus_DefaultMinAgreement(t *testing.T) {
 	logger := &mockLogger{}
 
 	// 3 resolvers, default should be (3/2)+1 = 2
-	resolvers := []resolver{
+	resolvers := []Resolver{
 		&mockResolver{name: "resolver1", response: []Record{{Value: "1.1.1.1", TTL: 300}}},
 		&mockResolver{name: "resolver2", response: []Record{{Value: "1.1.1.1", TTL: 300}}},
 		&mockResolver{name: "resolver3", response: []Record{{Value: "2.2.2.2", TTL: 300}}},
@@ -170,7 +171,7 @@ func TestFallback_FirstResolverSucceeds(t *testing.T) {
 	ctx := context.Background()
 	logger := &mockLogger{}
 
-	resolvers := []resolver{
+	resolvers := []Resolver{
 		&mockResolver{name: "resolver1", response: []Record{{Value: "1.1.1.1", TTL: 300}}},
 		&mockResolver{name: "resolver2", response: []Record{{Value: "2.2.2.2", TTL: 300}}},
 	}
@@ -187,7 +188,7 @@ func TestFallback_FirstResolverFails(t *testing.T) {
 	ctx := context.Background()
 	logger := &mockLogger{}
 
-	resolvers := []resolver{
+	resolvers := []Resolver{
 		&mockResolver{name: "resolver1", err: errors.New("timeout")},
 		&mockResolver{name: "resolver2", response: []Record{{Value: "2.2.2.2", TTL: 300}}},
 	}
@@ -204,7 +205,7 @@ func TestFallback_AllResolversFail(t *testing.T) {
 	ctx := context.Background()
 	logger := &mockLogger{}
 
-	resolvers := []resolver{
+	resolvers := []Resolver{
 		&mockResolver{name: "resolver1", err: errors.New("timeout")},
 		&mockResolver{name: "resolver2", err: errors.New("server failure")},
 	}
@@ -216,12 +217,53 @@ func TestFallback_AllResolversFail(t *testing.T) {
 	assert.Nil(t, records)
 }
 
+func TestFallback_Attempts(t *testing.T) {
+	ctx := context.Background()
+	logger := &mockLogger{}
+
+	resolvers := []Resolver{
+		&mockResolver{name: "resolver1", err: errors.New("timeout")},
+		&mockResolver{name: "resolver2", err: errors.New("timeout")},
+	}
+
+	_, err := Fallback{Attempts: 3}.ResolveType(ctx, "example.com", TypeA, resolvers, logger)
+	var aggErr *AggregateError
+	require.ErrorAs(t, err, &aggErr)
+	assert.Len(t, aggErr.Errors, 6)
+
+	// Another round won't make a host that doesn't exist appear
+	resolvers = []Resolver{
+		&mockResolver{name: "resolver1", err: &NotFoundError{Host: "example.com", NXDomain: true}},
+	}
+	_, err = Fallback{Attempts: 3}.ResolveType(ctx, "example.com", TypeA, resolvers, logger)
+	require.ErrorAs(t, err, &aggErr)
+	assert.Len(t, aggErr.Errors, 1)
+}
+
+func TestFallback_Rotate(t *testing.T) {
+	ctx := context.Background()
+	logger := &mockLogger{}
+
+	resolvers := []Resolver{
+		&mockResolver{name: "resolver1", response: []Record{{Value: "1.1.1.1", TTL: 300}}},
+		&mockResolver{name: "resolver2", response: []Record{{Value: "2.2.2.2", TTL: 300}}},
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		records, err := Fallback{Rotate: true}.ResolveType(ctx, "example.com", TypeA, resolvers, logger)
+		require.NoError(t, err)
+		seen[records[0].Value] = true
+	}
+	assert.Len(t, seen, 2)
+}
+
 func TestCompare_NoDiscrepancy(t *testing.T) {
 	ctx := context.Background()
 	logger := &mockLogger{}
 	var discrepancyCalled bool
 
-	resolvers := []resolver{
+	resolvers := []Resolver{
 		&mockResolver{name: "resolver1", response: []Record{{Value: "1.1.1.1", TTL: 300}}},
 		&mockResolver{name: "resolver2", response: []Record{{Value: "1.1.1.1", TTL: 300}}},
 	}
@@ -246,7 +288,7 @@ func TestCompare_WithDiscrepancy(t *testing.T) {
 	var discrepancyCalled bool
 	var discrepancyHost string
 
-	resolvers := []resolver{
+	resolvers := []Resolver{
 		&mockResolver{name: "resolver1", response: []Record{{Value: "1.1.1.1", TTL: 300}}},
 		&mockResolver{name: "resolver2", response: []Record{{Value: "2.2.2.2", TTL: 300}}},
 	}
@@ -271,7 +313,7 @@ func TestCompare_IgnoreTTLDifferences(t *testing.T) {
 	logger := &mockLogger{}
 	var discrepancyCalled bool
 
-	resolvers := []resolver{
+	resolvers := []Resolver{
 		&mockResolver{name: "resolver1", response: []Record{{Value: "1.1.1.1", TTL: 300}}},
 		&mockResolver{name: "resolver2", response: []Record{{Value: "1.1.1.1", TTL: 600}}},
 	}
@@ -295,7 +337,7 @@ func TestCompare_NoOnDiscrepancyCallback(t *testing.T) {
 	ctx := context.Background()
 	logger := &mockLogger{}
 
-	resolvers := []resolver{
+	resolvers := []Resolver{
 		&mockResolver{name: "resolver1", response: []Record{{Value: "1.1.1.1", TTL: 300}}},
 		&mockResolver{name: "resolver2", response: []Record{{Value: "2.2.2.2", TTL: 300}}},
 	}
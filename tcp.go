@@ -0,0 +1,79 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// tcpResolver implements the Resolver interface using TCP transport.
+//
+// Queries are pipelined (RFC 7766): a handful of pooled connections carry many
+// concurrent queries each, so we don't pay a TCP handshake per lookup. This is also
+// what udpResolver falls back to when a UDP response comes back truncated.
+type tcpResolver struct {
+	// addr is the DNS resolver address with port (e.g., "8.8.8.8:53")
+	addr string
+
+	// timeout is the default timeout we use if the context has no deadline set
+	timeout time.Duration
+
+	// pool holds the pipelined connections we send queries over
+	pool *pipelinePool
+}
+
+func newTCPResolver(addr string, timeout time.Duration, poolSize int) *tcpResolver {
+	addr = withDefaultPort(addr, "53")
+
+	dialer := &net.Dialer{Timeout: timeout}
+	dial := func(ctx context.Context) (net.Conn, error) {
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+
+	return &tcpResolver{
+		addr:    addr,
+		timeout: timeout,
+		pool:    newPipelinePool(dial, timeout, poolSize),
+	}
+}
+
+func (r *tcpResolver) ResolveType(ctx context.Context, host string, qtype RecordType) ([]Record, error) {
+	response, err := r.exchange(ctx, newQuery(host, qtype))
+	if err != nil {
+		return nil, err
+	}
+	return parseResponse(response)
+}
+
+// exchange sends msg over one of the pooled connections and returns the response.
+func (r *tcpResolver) exchange(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	// We prefer the context deadline if set, otherwise use the resolver's default timeout
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(r.timeout)
+	}
+
+	return r.pool.exchange(ctx, msg, deadline)
+}
+
+func (r *tcpResolver) Name() string {
+	return "tcp://" + r.addr
+}
+
+// discardedResponses returns the number of responses dropped because they didn't match
+// any query in flight.
+func (r *tcpResolver) discardedResponses() uint64 {
+	return r.pool.discarded.Load()
+}
+
+// Close closes all pooled connections.
+func (r *tcpResolver) Close() error {
+	return r.pool.Close()
+}
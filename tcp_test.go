@@ -0,0 +1,130 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startTestDNSServer runs a local DNS server on both UDP and TCP on the same port
+// and returns its address. The servers are shut down when the test finishes.
+func startTestDNSServer(t testing.TB, handler dns.HandlerFunc) string {
+	t.Helper()
+
+	// The port picked for UDP may already be taken for TCP, so try a few ports
+	var (
+		pc   net.PacketConn
+		l    net.Listener
+		addr string
+		err  error
+	)
+	for range 10 {
+		pc, err = net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		addr = pc.LocalAddr().String()
+
+		l, err = net.Listen("tcp", addr)
+		if err == nil {
+			break
+		}
+		_ = pc.Close()
+	}
+	require.NoError(t, err)
+
+	var started sync.WaitGroup
+	started.Add(2)
+	udpServer := &dns.Server{PacketConn: pc, Handler: handler, NotifyStartedFunc: started.Done}
+	tcpServer := &dns.Server{Listener: l, Handler: handler, NotifyStartedFunc: started.Done}
+	go func() { _ = udpServer.ActivateAndServe() }()
+	go func() { _ = tcpServer.ActivateAndServe() }()
+	started.Wait()
+
+	t.Cleanup(func() {
+		_ = udpServer.Shutdown()
+		_ = tcpServer.Shutdown()
+	})
+
+	return addr
+}
+
+// isTCP reports whether the request was received over TCP.
+func isTCP(w dns.ResponseWriter) bool {
+	_, ok := w.RemoteAddr().(*net.TCPAddr)
+	return ok
+}
+
+func TestUDPResolver_TruncatedFallsBackToTCP(t *testing.T) {
+	addr := startTestDNSServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		if !isTCP(w) {
+			// Pretend the answer didn't fit, and only include part of it
+			resp.Truncated = true
+			resp.Answer = append(resp.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.ParseIP("192.0.2.1"),
+			})
+		} else {
+			for _, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+				resp.Answer = append(resp.Answer, &dns.A{
+					Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+					A:   net.ParseIP(ip),
+				})
+			}
+		}
+		_ = w.WriteMsg(resp)
+	})
+
+	r := newUDPResolver(addr, time.Second, 2)
+	defer r.Close()
+
+	records, err := r.ResolveType(context.Background(), "example.com", TypeA)
+
+	assert.NoError(t, err)
+	assert.Len(t, records, 3)
+}
+
+func TestTCPResolver_PipelinedQueries(t *testing.T) {
+	addr := startTestDNSServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP("192.0.2.1"),
+		})
+		_ = w.WriteMsg(resp)
+	})
+
+	r := newTCPResolver(addr, time.Second, 1)
+	defer r.Close()
+
+	// Fire off a burst of concurrent queries, they all have to share a single connection
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := r.ResolveType(context.Background(), "example.com", TypeA)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.LessOrEqual(t, len(r.pool.conns), 1)
+}
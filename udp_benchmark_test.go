@@ -0,0 +1,104 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// These benchmarks compare the multiplexed UDP transport against the previous design,
+// where connPool hands out one socket per in-flight query. Both run against a local
+// server so they measure the transport rather than network latency, with enough
+// parallelism to keep more queries in flight than the pool has sockets.
+//
+// Besides the usual ns/op and allocations, each benchmark reports how many distinct
+// sockets (source ports) the server saw, which is what connPool churns through under load.
+
+// benchmarkUDPServer starts a local DNS server that answers every A query and records
+// the source ports it receives queries from.
+func benchmarkUDPServer(b *testing.B) (string, func() int) {
+	var mu sync.Mutex
+	ports := make(map[int]struct{})
+
+	addr := startTestDNSServer(b, func(w dns.ResponseWriter, req *dns.Msg) {
+		if udpAddr, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+			mu.Lock()
+			ports[udpAddr.Port] = struct{}{}
+			mu.Unlock()
+		}
+
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP("192.0.2.1"),
+		})
+		_ = w.WriteMsg(resp)
+	})
+
+	sockets := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(ports)
+	}
+	return addr, sockets
+}
+
+func BenchmarkUDPTransport_ConnPool(b *testing.B) {
+	addr, sockets := benchmarkUDPServer(b)
+	pool := newConnPool(addr, 2*time.Second, 4)
+	defer pool.Close()
+
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			conn, err := pool.Get()
+			if err != nil {
+				b.Fatalf("get failed: %v", err)
+			}
+			_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+			dnsConn := &dns.Conn{Conn: conn}
+			if err := dnsConn.WriteMsg(newQuery("example.com", TypeA)); err != nil {
+				b.Fatalf("write failed: %v", err)
+			}
+			if _, err := dnsConn.ReadMsg(); err != nil {
+				b.Fatalf("read failed: %v", err)
+			}
+			pool.Put(conn)
+		}
+	})
+	b.StopTimer()
+
+	b.ReportMetric(float64(sockets()), "sockets")
+}
+
+func BenchmarkUDPTransport_Multiplexed(b *testing.B) {
+	addr, sockets := benchmarkUDPServer(b)
+	r := newUDPResolver(addr, 2*time.Second, 4)
+	defer r.Close()
+
+	ctx := context.Background()
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := r.exchange(ctx, newQuery("example.com", TypeA)); err != nil {
+				b.Fatalf("exchange failed: %v", err)
+			}
+		}
+	})
+	b.StopTimer()
+
+	b.ReportMetric(float64(sockets()), "sockets")
+}
@@ -0,0 +1,103 @@
+// Copyright 2025 Bruno Schaatsbergen. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsdialer
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUDPResolver_DiscardsMismatchedResponses(t *testing.T) {
+	addr := startTestDNSServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		answer := func(resp *dns.Msg, ip string) {
+			resp.Answer = append(resp.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: resp.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.ParseIP(ip),
+			})
+		}
+
+		// A reply with the wrong message ID, e.g. a late reply to an earlier query
+		wrongID := new(dns.Msg)
+		wrongID.SetReply(req)
+		wrongID.Id = req.Id + 1
+		answer(wrongID, "203.0.113.1")
+		_ = w.WriteMsg(wrongID)
+
+		// A reply with the right ID but for a different name, e.g. a spoofing attempt
+		wrongName := new(dns.Msg)
+		wrongName.SetReply(req)
+		wrongName.Question[0].Name = "evil.example."
+		answer(wrongName, "203.0.113.2")
+		_ = w.WriteMsg(wrongName)
+
+		// The real reply, with the name in different casing, which is allowed
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		resp.Question[0].Name = strings.ToUpper(req.Question[0].Name)
+		answer(resp, "192.0.2.1")
+		_ = w.WriteMsg(resp)
+	})
+
+	r := newUDPResolver(addr, time.Second, 1)
+	defer r.Close()
+
+	records, err := r.ResolveType(context.Background(), "example.com", TypeA)
+
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+	assert.Equal(t, "192.0.2.1", records[0].Value)
+	assert.Equal(t, uint64(2), r.discardedResponses())
+}
+
+func TestUDPResolver_OnlyMismatchedResponsesTimesOut(t *testing.T) {
+	addr := startTestDNSServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		resp.Question[0].Qtype = dns.TypeAAAA
+		_ = w.WriteMsg(resp)
+	})
+
+	dialer := New(
+		WithTimeout(100*time.Millisecond),
+		WithResolvers(addr),
+	)
+
+	_, err := dialer.resolvers[0].ResolveType(context.Background(), "example.com", TypeA)
+
+	assert.Error(t, err)
+	assert.Equal(t, map[string]uint64{addr: 1}, dialer.DiscardedResponses())
+}
+
+func TestUDPResolver_ShortDatagramKeepsSocketOpen(t *testing.T) {
+	addr := startTestDNSServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		// A datagram too short to even hold a DNS header, which anyone can send us
+		_, _ = w.Write([]byte{1, 2, 3, 4, 5})
+
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP("192.0.2.1"),
+		})
+		_ = w.WriteMsg(resp)
+	})
+
+	r := newUDPResolver(addr, time.Second, 1)
+	defer r.Close()
+
+	records, err := r.ResolveType(context.Background(), "example.com", TypeA)
+
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+	assert.Equal(t, "192.0.2.1", records[0].Value)
+	assert.Equal(t, uint64(1), r.discardedResponses())
+}
@@ -5,6 +5,11 @@
 
 package dnsdialer
 
+import (
+	"net"
+	"strings"
+)
+
 // recordKey is used as a map key for comparing DNS records.
 // It combines value and TTL to enable multiset equality checking.
 type recordKey struct {
@@ -71,3 +76,14 @@ func recordsEqual(a, b []Record, ignoreTTL bool) bool {
 	// If we get here, both slices contain the same records with the same frequencies
 	return true
 }
+
+// withDefaultPort ensures the address includes a port, appending the given default
+// when it's missing. This lets users specify just "8.8.8.8" instead of "8.8.8.8:53".
+func withDefaultPort(addr, port string) string {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		// A bracketed IPv6 address without a port, JoinHostPort adds the brackets itself
+		host := strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+		return net.JoinHostPort(host, port)
+	}
+	return addr
+}